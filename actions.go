@@ -0,0 +1,267 @@
+package main
+
+// Actions taken on the duplicates: --delete, --hardlink and --move-to.
+//
+// In every case the first file of each group, the keeper (see keep.go), is left alone and the
+// action is applied to the rest. Unless --dry-run or --yes is given, a summary of what is
+// about to happen is shown and confirmation is read from stdin first.
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+
+// actionWords describe each action: what it does, what it did, and what it was doing.
+var actionWords = map[string][3]string{
+	"delete":   {"delete", "deleted", "deleting"},
+	"hardlink": {"hardlink", "linked", "linking"},
+	"move":     {"move", "moved", "moving"},
+}
+
+
+// actionErrorModes are the valid values for --on-action-error.
+var actionErrorModes = []string{"abort", "continue"}
+
+
+// selectedAction returns the name of the action requested, or an empty string.
+func selectedAction() string {
+	switch {
+	case *Delete:
+		return "delete"
+	case *Hardlink:
+		return "hardlink"
+	case *MoveTo != "":
+		return "move"
+	}
+	return ""
+}
+
+
+// validateAction makes sure at most one action was requested.
+func validateAction() error {
+	selected := 0
+	for _, chosen := range []bool{*Delete, *Hardlink, *MoveTo != ""} {
+		if chosen {
+			selected++
+		}
+	}
+	if selected > 1 {
+		return fmt.Errorf("only one of --delete, --hardlink and --move-to may be used")
+	}
+	return nil
+}
+
+
+// formatCount renders a number with thousands separators.
+func formatCount(n int64) string {
+	digits := fmt.Sprintf("%d", n)
+	if n < 0 {
+		return "-" + formatCount(-n)
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return digits
+}
+
+
+// formatBytes renders a byte count in the largest binary unit that keeps it above one.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	value, suffix := float64(n)/unit, 0
+	for value >= unit && suffix < 4 {
+		value /= unit
+		suffix++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[suffix])
+}
+
+
+// confirmAction describes what an action is about to do and asks for confirmation on stdin.
+func confirmAction(action string, groups []Group) bool {
+	var files, reclaim int64
+	for _, group := range groups {
+		files += int64(group.Count() - 1)
+		reclaim += group.WastedBytes()
+	}
+
+	fmt.Fprintf(os.Stderr, "About to %s %s files reclaiming %s across %s groups. Proceed? [y/N] ",
+		actionWords[action][0], formatCount(files), formatBytes(reclaim), formatCount(int64(len(groups))))
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+
+// hardlink replaces duplicate with a hard link to keeper, via a temporary link alongside it so
+// that the duplicate is never missing.
+func hardlink(keeper, duplicate string) error {
+	temporary := duplicate + ".findupe-link"
+	if err := os.Link(keeper, temporary); err != nil {
+		return err
+	}
+	if err := os.Rename(temporary, duplicate); err != nil {
+		os.Remove(temporary)
+		return err
+	}
+	return nil
+}
+
+
+// moveTo moves duplicate under the --move-to directory, keeping its path relative to its
+// root.
+func moveTo(duplicate string) (string, error) {
+	destination := filepath.Join(*MoveTo, filepath.FromSlash(relativePath(duplicate)))
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(destination); err == nil {
+		return "", fmt.Errorf("%s already exists", destination)
+	}
+	return destination, os.Rename(duplicate, destination)
+}
+
+
+// unlinked drops the files which are their keeper under another name, through a hard link or
+// a followed symlink, from each group, returning the groups with anything left to act on and
+// how many were dropped. Deleting or moving one of those would take the keeper with it.
+// With --symlink-mode=hash-link the links themselves are what was grouped, so they are
+// compared rather than what they point at.
+func unlinked(groups []Group) ([]Group, int64) {
+	stat := os.Stat
+	if *SymlinkMode == "hash-link" {
+		stat = os.Lstat
+	}
+
+	var remaining []Group
+	var linked int64
+	for _, group := range groups {
+		keeper, err := stat(group.Files[0])
+		if err != nil {
+			remaining = append(remaining, group)
+			continue
+		}
+
+		files := []string{group.Files[0]}
+		for _, duplicate := range group.Files[1:] {
+			if info, err := stat(duplicate); err == nil && os.SameFile(keeper, info) {
+				linked++
+				continue
+			}
+			files = append(files, duplicate)
+		}
+		if len(files) > 1 {
+			group.Files = files
+			remaining = append(remaining, group)
+		}
+	}
+	return remaining, linked
+}
+
+
+// performAction applies the selected action to all but the keeper of each group.
+func performAction(action string, groups []Group) {
+	// Files which are already their keeper are left alone, so that relinking is a no-op and
+	// a symlink and its target can't both be deleted as copies of each other.
+	groups, alreadyLinked := unlinked(groups)
+
+	if len(groups) > 0 && !*DryRun && !*Yes && !confirmAction(action, groups) {
+		log.Print("Aborted, nothing was changed.")
+		return
+	}
+
+	words := actionWords[action]
+	var acted, failed, reclaimed, abandoned int64
+	var actionErrors []string
+	for _, group := range groups {
+		keeper := group.Files[0]
+
+		// Whatever --on-action-error says, nothing is done to a group whose keeper has gone
+		// or become unreadable since it was hashed, as that could leave no copy at all.
+		if !*DryRun {
+			if err := checkKeeper(keeper); err != nil {
+				abandoned++
+				fileError("keeping", keeper, err)
+				actionErrors = append(actionErrors, fmt.Sprintf("keeping %q: %s", keeper, err))
+				continue
+			}
+		}
+
+		for i, duplicate := range group.Files[1:] {
+			if *DryRun {
+				fmt.Printf("would %s: %q\n", words[0], duplicate)
+				acted++
+				reclaimed += group.Size
+				continue
+			}
+
+			var err error
+			switch action {
+			case "delete":
+				err = os.Remove(duplicate)
+			case "hardlink":
+				err = hardlink(keeper, duplicate)
+			case "move":
+				var destination string
+				if destination, err = moveTo(duplicate); err == nil {
+					fmt.Printf("%s: %q -> %q\n", words[1], duplicate, destination)
+				}
+			}
+			if err != nil {
+				failed++
+				fileError(words[2], duplicate, err)
+				actionErrors = append(actionErrors, fmt.Sprintf("%s %q: %s", words[2], duplicate, err))
+				if *OnActionError == "abort" {
+					if rest := len(group.Files) - 2 - i; rest > 0 {
+						log.Printf("leaving the other %d files of the group of %q alone", rest, keeper)
+					}
+					abandoned++
+					break
+				}
+				continue
+			}
+			if action != "move" {
+				fmt.Printf("%s: %q\n", words[1], duplicate)
+			}
+			acted++
+			reclaimed += group.Size
+		}
+	}
+
+	if *DryRun {
+		log.Print("Dry run: would ", words[0], " ", acted, " files, reclaiming ", formatBytes(reclaimed))
+	} else {
+		log.Print("Actioned:", acted, ", Failed:", failed, ", Reclaimed:", formatBytes(reclaimed))
+	}
+	if action == "hardlink" {
+		log.Print(acted, " new links, ", alreadyLinked, " already linked")
+	} else if alreadyLinked > 0 {
+		log.Print(alreadyLinked, " files left alone as links to the file being kept")
+	}
+	if len(actionErrors) > 0 {
+		log.Print("Action errors: ", len(actionErrors), ", groups left unfinished: ", abandoned)
+		for _, actionError := range actionErrors {
+			log.Print("  ", actionError)
+		}
+	}
+}
+
+
+// checkKeeper makes sure the file being kept is still there and readable before anything is
+// done to its duplicates.
+func checkKeeper(keeper string) error {
+	file, err := os.Open(keeper)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
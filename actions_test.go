@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+
+// groupsOf makes the groups an action would be given from the buckets of a scan.
+func groupsOf(collisions CollisionTable) []Group {
+	var groups []Group
+	for hash, files := range collisions {
+		groups = append(groups, newGroup(hash, files))
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Files[0] < groups[j].Files[0] })
+	return groups
+}
+
+
+func TestHardlinkTwice(t *testing.T) {
+	setFlag(t, "min-bytes", "1")
+	setFlag(t, "yes", "true")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a": "linked", "b": "linked", "c": "linked", "d": "alone"})
+
+	performAction("hardlink", groupsOf(scan(t, dir)))
+
+	keeper, err := os.Stat(filepath.Join(dir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b", "c"} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || !os.SameFile(keeper, info) {
+			t.Fatalf("%s wasn't linked to a", name)
+		}
+	}
+
+	// The second run finds the same group, but has nothing left to do to it.
+	collisions := scan(t, dir)
+	checkGroups(t, dir, collisions, "a b c")
+	groups := groupsOf(collisions)
+	if remaining, alreadyLinked := unlinked(groups); len(remaining) != 0 || alreadyLinked != 2 {
+		t.Errorf("%d groups left to link and %d files already linked, want 0 and 2", len(remaining), alreadyLinked)
+	}
+
+	// Relinking would have gone through a temporary link in the directory.
+	before, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	performAction("hardlink", groups)
+	if after, err := os.Stat(dir); err != nil || !after.ModTime().Equal(before.ModTime()) {
+		t.Errorf("the second run changed the directory")
+	}
+}
+
+
+func TestUnlinkedHashLink(t *testing.T) {
+	setFlag(t, "symlink-mode", "hash-link")
+	dir := symlinkTree(t)
+
+	// The two links point at the same file, but are links of their own to act on.
+	groups := groupsOf(scan(t, dir))
+	remaining, alreadyLinked := unlinked(groups)
+	if len(remaining) != 2 || alreadyLinked != 0 {
+		t.Errorf("%d groups left to act on and %d files already linked, want 2 and 0", len(remaining), alreadyLinked)
+	}
+}
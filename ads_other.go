@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Alternate data streams only exist on Windows.
+
+
+// streamsFingerprint has nothing to add outside of Windows.
+func streamsFingerprint(pathname string) (string, error) {
+	return "", nil
+}
+
+
+// streamsSettings has nothing to add to cacheSettings outside of Windows.
+func streamsSettings() string {
+	return ""
+}
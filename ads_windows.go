@@ -0,0 +1,97 @@
+package main
+
+// NTFS alternate data streams.
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"syscall"
+	"unsafe"
+)
+
+
+var (
+	kernel32            = syscall.NewLazyDLL("kernel32.dll")
+	procFindFirstStream = kernel32.NewProc("FindFirstStreamW")
+	procFindNextStream  = kernel32.NewProc("FindNextStreamW")
+)
+
+
+// findStreamData mirrors WIN32_FIND_STREAM_DATA.
+type findStreamData struct {
+	StreamSize int64
+	StreamName [syscall.MAX_PATH + 36]uint16
+}
+
+
+// alternateStreams lists the names (":name:$DATA") of a file's alternate data streams, not
+// including the unnamed primary stream.
+func alternateStreams(pathname string) ([]string, error) {
+	name, err := syscall.UTF16PtrFromString(pathname)
+	if err != nil {
+		return nil, err
+	}
+
+	var data findStreamData
+	handle, _, err := procFindFirstStream.Call(uintptr(unsafe.Pointer(name)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if syscall.Handle(handle) == syscall.InvalidHandle {
+		if err == syscall.ERROR_HANDLE_EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	defer syscall.FindClose(syscall.Handle(handle))
+
+	var streams []string
+	for {
+		if stream := syscall.UTF16ToString(data.StreamName[:]); stream != "::$DATA" {
+			streams = append(streams, stream)
+		}
+		ok, _, err := procFindNextStream.Call(handle, uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if err == syscall.ERROR_HANDLE_EOF {
+				break
+			}
+			return nil, err
+		}
+	}
+
+	sort.Strings(streams)
+
+	return streams, nil
+}
+
+
+// streamsSettings describes --include-ads for cacheSettings.
+func streamsSettings() string {
+	return fmt.Sprintf(" include-ads=%v", *IncludeADS)
+}
+
+
+// streamsFingerprint produces a hash of the names and content of a file's alternate data
+// streams with --include-ads, or an empty string if there are none or it wasn't requested.
+func streamsFingerprint(pathname string) (string, error) {
+	if !*IncludeADS {
+		return "", nil
+	}
+
+	streams, err := alternateStreams(pathname)
+	if err != nil || len(streams) == 0 {
+		return "", err
+	}
+
+	hasher := sha512.New()
+	for _, stream := range streams {
+		streamHash, err := hashData(pathname+stream, sha512.New())
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(hasher, stream+"\x00"+streamHash+"\x00")
+	}
+
+	return "ads." + hex.EncodeToString(hasher.Sum(nil)), nil
+}
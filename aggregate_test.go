@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+
+// pairedReplies sends n replies in batches of two copies of a file, as --fast would, closing
+// the channel once they have all been sent.
+func pairedReplies(n int) <-chan *FileHash {
+	replies := make(chan *FileHash, 64)
+	go func() {
+		defer close(replies)
+		for i := 0; i < n; i += 2 {
+			batch := &Batch{pending: 2}
+			hash := fmt.Sprintf("%016d.pair.%d", 100, i)
+			replies <- &FileHash{Pathname: fmt.Sprintf("a/%d", i), Hash: hash, Batch: batch}
+			replies <- &FileHash{Pathname: fmt.Sprintf("b/%d", i), Hash: hash, Batch: batch}
+		}
+	}()
+	return replies
+}
+
+
+// streamingPeak streams n replies through aggregateHashes, returning the most memory the heap
+// held along the way and how many groups were emitted.
+func streamingPeak(n int) (uint64, int) {
+	var peak uint64
+	var stats runtime.MemStats
+	emitted := 0
+	emit := func(hash string, files []string) {
+		if emitted++; emitted%1024 == 0 {
+			runtime.GC()
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > peak {
+				peak = stats.HeapAlloc
+			}
+		}
+	}
+	aggregateHashes(pairedReplies(n), emit)
+	return peak, emitted
+}
+
+
+func TestStreamingAggregateMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("hashes half a million replies")
+	}
+	small, emittedSmall := streamingPeak(50000)
+	large, emittedLarge := streamingPeak(500000)
+	if emittedSmall != 25000 || emittedLarge != 250000 {
+		t.Fatalf("emitted %d and %d groups, want 25000 and 250000", emittedSmall, emittedLarge)
+	}
+
+	// Ten times the replies would take ten times the memory if the groups were held on to.
+	if large > small*2 {
+		t.Errorf("peak heap grew from %d to %d bytes for ten times the replies", small, large)
+	}
+}
+
+
+// mixedReplies makes n replies in which every fourth hash is unique and the rest come in
+// threes, in an order which spreads each group out.
+func mixedReplies(n int) []*FileHash {
+	replies := make([]*FileHash, 0, n)
+	for i := 0; len(replies) < n; i++ {
+		copies := 3
+		if i%4 == 0 {
+			copies = 1
+		}
+		for c := 0; c < copies && len(replies) < n; c++ {
+			replies = append(replies, &FileHash{Pathname: fmt.Sprintf("%d/%d", c, i), Hash: fmt.Sprintf("%016d.%d", 100, i)})
+		}
+	}
+	// Interleave the copies, as a walk would find them in different directories.
+	for i := range replies {
+		j := (i * 7919) % len(replies)
+		replies[i], replies[j] = replies[j], replies[i]
+	}
+	return replies
+}
+
+
+// feed sends replies down a channel, closing it after the last.
+func feed(replies []*FileHash) <-chan *FileHash {
+	channel := make(chan *FileHash, 1024)
+	go func() {
+		defer close(channel)
+		for _, reply := range replies {
+			channel <- reply
+		}
+	}()
+	return channel
+}
+
+
+// twoMapAggregate is how aggregateHashes used to work, moving each hash from a map of singles
+// to one of collisions when its second file turned up, for BenchmarkAggregateHashes to compare.
+func twoMapAggregate(replies <-chan *FileHash) CollisionTable {
+	singles := make(map[string]string)
+	collisions := make(CollisionTable)
+	for reply := range replies {
+		if files, exists := collisions[reply.Hash]; exists {
+			collisions[reply.Hash] = append(files, reply.Pathname)
+		} else if first, exists := singles[reply.Hash]; exists {
+			collisions[reply.Hash] = []string{first, reply.Pathname}
+			delete(singles, reply.Hash)
+		} else {
+			singles[reply.Hash] = reply.Pathname
+		}
+	}
+	return collisions
+}
+
+
+func TestAggregateHashes(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	replies := []*FileHash{
+		{Pathname: "a/1", Hash: "one"}, {Pathname: "b/1", Hash: "one"}, {Pathname: "c/1", Hash: "one"},
+		{Pathname: "a/2", Hash: "two"}, {Pathname: "b/2", Hash: "two"},
+		{Pathname: "a/3", Hash: "three"}, {Pathname: "a/4", Hash: "four"}, {Pathname: "a/5", Hash: "five"},
+	}
+	collisions := aggregateHashes(feed(replies), nil)
+
+	want := CollisionTable{"one": {"a/1", "b/1", "c/1"}, "two": {"a/2", "b/2"}}
+	if !reflect.DeepEqual(collisions, want) {
+		t.Errorf("collisions %v, want %v", collisions, want)
+	}
+	if summary := "Misses:3, Collisions:5, Hashes:2, Dupes:3"; !strings.Contains(logged.String(), summary) {
+		t.Errorf("logged %q, want %q", logged.String(), summary)
+	}
+
+	// The same buckets as the two maps used to give, for a larger and more mixed set.
+	mixed := mixedReplies(10000)
+	if got, want := aggregateHashes(feed(mixed), nil), twoMapAggregate(feed(mixed)); !reflect.DeepEqual(got, want) {
+		t.Errorf("%d buckets, but the two maps gave %d", len(got), len(want))
+	}
+}
+
+
+func BenchmarkAggregateHashes(b *testing.B) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	replies := mixedReplies(2000000)
+	b.Run("one map", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			aggregateHashes(feed(replies), nil)
+		}
+	})
+	b.Run("two maps", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			twoMapAggregate(feed(replies))
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+// Per-extension hash algorithms, for --algo-map.
+//
+// The map is a list of ext=algorithm rules, such as "mp4=crc32,mkv=crc32,*=sha256", where "*"
+// covers every extension without a rule of its own. Files without any matching rule use
+// sha512. The algorithm is part of the key, so files hashed with different algorithms never
+// match each other; they would have needed the same extension, and so the same rule, anyway.
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+
+// defaultAlgorithm is used for files which no rule covers.
+const defaultAlgorithm = "sha512"
+
+
+// algorithms are the hashes --algo-map can choose between.
+var algorithms = map[string]func() hash.Hash{
+	"crc32":  func() hash.Hash { return crc32.NewIEEE() },
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+
+// multiAlgoPrefix marks the keys of --multi-algo, which are the sha256 and blake2b-512 of a
+// file joined by a dot.
+const multiAlgoPrefix = "sha256+blake2b:"
+
+
+// newBlake2b returns an unkeyed blake2b-512 hasher.
+func newBlake2b() hash.Hash {
+	hasher, _ := blake2b.New512(nil)
+	return hasher
+}
+
+
+// algoRules maps lower-case extensions, without the dot, or "*" to the algorithm to use.
+var algoRules = make(map[string]string)
+
+
+// parseAlgoMap reads the --algo-map rules.
+func parseAlgoMap() error {
+	for _, rule := range strings.Split(*AlgoMap, ",") {
+		if rule = strings.TrimSpace(rule); rule == "" {
+			continue
+		}
+		equals := strings.IndexByte(rule, '=')
+		if equals < 0 {
+			return fmt.Errorf("%q should be ext=algorithm", rule)
+		}
+		ext := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(rule[:equals]), "."))
+		algorithm := strings.ToLower(strings.TrimSpace(rule[equals+1:]))
+		if _, ok := algorithms[algorithm]; !ok {
+			names := make([]string, 0, len(algorithms))
+			for name := range algorithms {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown algorithm %q for %s, expected one of %s", algorithm, ext, strings.Join(names, ", "))
+		}
+		algoRules[ext] = algorithm
+	}
+	return nil
+}
+
+
+// algorithmFor returns the name of the algorithm to hash a file with.
+func algorithmFor(pathname string) string {
+	if algorithm, ok := algoRules[strings.ToLower(strings.TrimPrefix(filepath.Ext(pathname), "."))]; ok {
+		return algorithm
+	}
+	if algorithm, ok := algoRules["*"]; ok {
+		return algorithm
+	}
+	return defaultAlgorithm
+}
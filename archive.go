@@ -0,0 +1,183 @@
+package main
+
+// Archive-aware hashing, for --archive-aware.
+//
+// Zip and tar archives (optionally gzipped) are hashed on the sorted list of their members'
+// names, sizes and content hashes rather than on the archive's own bytes, so archives holding
+// the same files match even if they were compressed differently, or the members were added in
+// a different order or with different timestamps. The size of the archive itself is left out
+// of the key for the same reason. Anything that doesn't open as an archive is hashed as usual.
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+
+// archivePrefix marks the hash of an archive's members.
+const archivePrefix = "archive:"
+
+
+// archiveMember is a file inside an archive.
+type archiveMember struct {
+	name string
+	size int64
+	hash string
+}
+
+
+// archiveKind returns "zip" or "tar" for files whose extension says they are an archive, or
+// an empty string.
+func archiveKind(pathname string) string {
+	lower := strings.ToLower(pathname)
+	switch {
+	case strings.HasSuffix(lower, ".zip"), strings.HasSuffix(lower, ".jar"):
+		return "zip"
+	case strings.HasSuffix(lower, ".tar"), strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return "tar"
+	}
+	return ""
+}
+
+
+// isArchiveHash reports whether a hash is of an archive's members.
+func isArchiveHash(hashString string) bool {
+	return strings.HasPrefix(hashString, archivePrefix)
+}
+
+
+// memberHash hashes the content of an archive member.
+func memberHash(reader io.Reader) (string, int64, error) {
+	hasher := sha512.New()
+	size, err := io.Copy(hasher, reader)
+	return hex.EncodeToString(hasher.Sum(nil)), size, err
+}
+
+
+// readerAt adapts a File to io.ReaderAt for archive/zip, which only reads it from one
+// goroutine at a time.
+type readerAt struct {
+	file File
+}
+
+
+// ReadAt implements io.ReaderAt.
+func (r readerAt) ReadAt(p []byte, offset int64) (int, error) {
+	if _, err := r.file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(r.file, p)
+}
+
+
+// readMembers calls visit with the content of each file in an archive of the given kind; a
+// tar archive may be gzipped.
+func readMembers(file File, pathname, kind string, visit func(name string, info os.FileInfo, content io.Reader) error) error {
+	if kind == "zip" {
+		size, err := file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		return zipMembers(file, size, visit)
+	}
+	return tarMembers(file, pathname, visit)
+}
+
+
+// zipMembers reads the files in a zip archive.
+func zipMembers(file File, size int64, visit func(name string, info os.FileInfo, content io.Reader) error) error {
+	archive, err := zip.NewReader(readerAt{file}, size)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		content, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = visit(entry.Name, entry.FileInfo(), content)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+
+// tarMembers reads the regular files in a tar archive, which may be gzipped.
+func tarMembers(file File, pathname string, visit func(name string, info os.FileInfo, content io.Reader) error) error {
+	var reader io.Reader = throttle(file)
+	if lower := strings.ToLower(pathname); strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		unzipped, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer unzipped.Close()
+		reader = unzipped
+	}
+
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := visit(header.Name, header.FileInfo(), archive); err != nil {
+			return err
+		}
+	}
+}
+
+
+// archiveFingerprint hashes the members of an archive, or returns an empty string if the file
+// isn't one that can be read.
+func archiveFingerprint(pathname string) string {
+	kind := archiveKind(pathname)
+	if kind == "" {
+		return ""
+	}
+
+	file, err := openFile(pathname)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	var members []archiveMember
+	err = readMembers(file, pathname, kind, func(name string, _ os.FileInfo, content io.Reader) error {
+		hash, size, err := memberHash(content)
+		if err == nil {
+			members = append(members, archiveMember{name: name, size: size, hash: hash})
+		}
+		return err
+	})
+	if err != nil {
+		return ""
+	}
+
+	sort.Slice(members, func(i, j int) bool { return members[i].name < members[j].name })
+	hasher := sha512.New()
+	for _, member := range members {
+		fmt.Fprintf(hasher, "%q %d %s\n", member.name, member.size, member.hash)
+	}
+	return archivePrefix + hex.EncodeToString(hasher.Sum(nil))
+}
@@ -3,20 +3,336 @@ package main
 // Command line arguments.
 
 import (
+	"time"
+
 	flag "github.com/spf13/pflag"
 )
 
-// BasePath is the top-level of the crawl.
-var BasePath = flag.StringP("path", "p", ".", "Directory to recurse over.")
+// Config names a file of defaults for the other options.
+var Config = flag.String("config", "", "Read defaults for the other options from this file (default ~/.findupe.toml, if it exists).")
+
+// BasePath is the top-level of the crawl; more can be given as arguments.
+var BasePath = flag.StringP("path", "p", ".", "Directory to recurse over (more can be given as arguments).")
 
 // MinBytes specifies the minimum size a file must be to be compared.
 var MinBytes = flag.IntP("min-bytes", "b", 256, "Minimum size (bytes) for file to consider.")
 
+// IncludeEmpty compares zero-byte files, which are otherwise skipped whatever MinBytes is.
+var IncludeEmpty = flag.Bool("include-empty", false, "Report zero-byte files as duplicates of each other instead of skipping them.")
+
+// MinDupeBytes hides groups which waste less space than this.
+var MinDupeBytes = flag.Int64("min-dupe-bytes", 0, "Only report groups whose duplicates waste at least this many bytes ((count-1)*size).")
+
+// MinCount hides groups with fewer files than this.
+var MinCount = flag.Int("min-count", 2, "Only report groups of at least this many files.")
+
+// VerifyChecksums checks the files listed in a sha512sum-style manifest instead of looking for
+// duplicates.
+var VerifyChecksums = flag.String("verify-checksums", "", "Check the files in this sha512sum-style manifest, reporting any that differ, are missing or aren't listed.")
+
+// ByCategory logs the duplicates and reclaimable space of each kind of content.
+var ByCategory = flag.Bool("by-category", false, "Log the duplicates and reclaimable space in each category: images, videos, audio, documents, code, other.")
+
+// RankDirs logs how much space removing the duplicates would free in each top-level directory.
+var RankDirs = flag.Bool("rank-dirs", false, "Log the space the duplicates take up in each top-level directory, largest first.")
+
+// Histogram logs how many of the hashed files fall into each range of sizes.
+var Histogram = flag.Bool("histogram", false, "Log a histogram of the sizes of the hashed files.")
+
+// LimitGroups only lists this many of the groups which waste the most space.
+var LimitGroups = flag.Int("limit-groups", 0, "Only list the N groups which waste the most space, largest first (0 for all).")
+
+// ReportFilter only reports groups with a file whose path matches this regular expression.
+var ReportFilter = flag.String("report-filter", "", "Only report groups with a file whose path matches this regular expression.")
+
+// ReportExtensions only reports groups with a file with one of these extensions.
+var ReportExtensions = flag.StringSlice("report-ext", nil, "Only report groups with a file with one of these extensions (hashing is unaffected).")
+
+// ReportFilterAll requires every file of a group to match ReportFilter and ReportExtensions
+// rather than any one.
+var ReportFilterAll = flag.Bool("report-filter-all", false, "Only report groups whose files all match --report-filter and --report-ext.")
+
+// CrossRootOnly hides groups whose files are all under the same root.
+var CrossRootOnly = flag.Bool("cross-root-only", false, "Only report groups with files under at least two of the root paths.")
+
 // Jobs (threads) is how many workers to run concurrently.
 var Threads = flag.IntP("threads", "j", 9, "Number of concurrent workers.")
 
+// AutoThreads picks Threads from the kind of storage the roots are on.
+var AutoThreads = flag.Bool("auto-threads", false, "Pick --threads from the storage: more for SSD/NVMe, fewer for spinning disks, else one per CPU.")
+
+// Deterministic makes the reports and logs identical between runs over the same tree.
+var Deterministic = flag.Bool("deterministic", false, "Hash one file at a time in walk order, so reports and logs are identical between runs.")
+
+// WorkerAffinity gives each device its own pool of DeviceThreads workers instead of sharing
+// Threads workers between them.
+var WorkerAffinity = flag.Bool("worker-affinity", false, "Give each device its own pool of --device-threads workers (ignores --threads).")
+
+// DeviceThreads is the number of workers per device with --worker-affinity.
+var DeviceThreads = flag.Int("device-threads", 2, "Number of concurrent workers per device with --worker-affinity.")
+
+// MultiAlgo keys files on both their sha256 and blake2b hashes, computed from a single read.
+var MultiAlgo = flag.Bool("multi-algo", false, "Key files on both a sha256 and a blake2b-512 hash, computed in a single read.")
+
 // Thorough will do an md5 on files after the sha512.
 var Thorough = flag.BoolP("thorough", "T", false, "Append SHA sums with MD5 sums.")
 
+// ArchiveAware hashes zip and tar archives on their members rather than their bytes.
+var ArchiveAware = flag.Bool("archive-aware", false, "Match zip and tar archives on the names, sizes and content of their members.")
+
+// IntoArchives also hashes the members of zip and tar archives, as files in their own right.
+var IntoArchives = flag.Bool("into-archives", false, "Also hash each member of zip and tar archives, reporting it as 'archive::member'.")
+
+// AlgoMap chooses the hash algorithm by file extension.
+var AlgoMap = flag.String("algo-map", "", "Hash algorithm per extension, e.g. 'mp4=crc32,*=sha256' (crc32, md5, sha1, sha256, sha512; default sha512).")
+
 // Present a listing of all the collisions.
-var ListCollisions = flag.BoolP("list-collisions", "L", false, "List files for which matches were found.")
\ No newline at end of file
+var ListCollisions = flag.BoolP("list-collisions", "L", false, "List files for which matches were found.")
+
+// OnMutation controls what happens to files that change while they are being hashed.
+var OnMutation = flag.String("on-mutation", "retry", "What to do with files that change while hashed: retry (once), discard, ignore.")
+
+// Fast narrows candidates by size and then by their first few KiB before full hashing.
+var Fast = flag.Bool("fast", false, "Only fully hash files whose size and first 4KiB match another file.")
+
+// SymlinkMode determines what is done with symlinks to files found during the walk.
+var SymlinkMode = flag.String("symlink-mode", "skip", "Symlinks to files: skip, follow (hash the target's content), hash-link (hash the link target string).")
+
+// Offset is where in each file to start comparing from.
+var Offset = flag.Int64("offset", 0, "Byte offset into each file at which to start hashing.")
+
+// SkipHeaderBytes is another way of giving Offset, for files with volatile headers.
+var SkipHeaderBytes = flag.Int64("skip-header-bytes", 0, "Ignore this many bytes of header at the start of each file (the same as --offset).")
+
+// Length limits how many bytes, from Offset, are compared; 0 means to the end of the file.
+var Length = flag.Int64("length", 0, "Number of bytes from --offset to hash (0 for the rest of the file).")
+
+// RangeStrict reports files too short to contain the requested range as errors.
+var RangeStrict = flag.Bool("range-strict", false, "Log files too short for --offset/--length as errors instead of quietly skipping them.")
+
+// Format selects how listings are written to stdout.
+var Format = flag.String("format", "text", "Output format for listings: text, json, json-stream, json-tree, yaml, fdupes, flat-sorted.")
+
+// DumpHashes lists the hash of every file rather than just the collisions.
+var DumpHashes = flag.Bool("dump-hashes", false, "Stream the hash of every file instead of reporting collisions.")
+
+// LiveManifest appends the hash of every file to this file as soon as it has been hashed.
+var LiveManifest = flag.String("live-manifest", "", "Append each file's hash to this file as soon as it is hashed, for --load-hashes to resume from.")
+
+// LoadHashes names a JSON Lines file of hashes to add to those found by this run.
+var LoadHashes = flag.String("load-hashes", "", "Seed the results with the {path,size,mtime,hash} records in this JSON Lines file, hashing only files they don't cover.")
+
+// Dirs reports directories whose entire contents are duplicated instead of files.
+var Dirs = flag.Bool("dirs", false, "Report directories with identical contents (largest first) instead of files.")
+
+// FollowTopSymlinks follows symlinks that are direct children of BasePath, but no deeper.
+var FollowTopSymlinks = flag.Bool("follow-top-symlinks", false, "Follow symlinks (including to directories) only when they are direct children of a root path.")
+
+// Template is a text/template executed for each collision group in the listing.
+var Template = flag.String("template", "", "Go text/template executed per collision group (fields: .Hash .Size .Count .Files .Notes .WastedBytes).")
+
+// RecentFirst holds back every request until the walk is done and dispatches the most
+// recently modified first.
+var RecentFirst = flag.Bool("recent-first", false, "Hash the most recently modified files first, once the whole tree has been walked.")
+
+// ShuffleWindow is how many requests to collect and shuffle before dispatching them.
+var ShuffleWindow = flag.Int("shuffle-window", 0, "Shuffle requests in windows of this many files to spread reads across the tree (0 to disable).")
+
+// Cache is where the hash of every file is saved at the end of a run.
+var Cache = flag.String("cache", "", "File to save the hash of every file to for use by --since-last-run.")
+
+// SinceLastRun reuses cached hashes for files which haven't changed since the last run.
+var SinceLastRun = flag.Bool("since-last-run", false, "Only hash files that are new or changed since the --cache was written.")
+
+// ReportEmptyDirs lists the empty directories under BasePath after everything else is done.
+var ReportEmptyDirs = flag.Bool("report-empty-dirs", false, "List any empty directories under --path once finished.")
+
+// PruneEmptyDirs removes the empty directories under BasePath after everything else is done.
+var PruneEmptyDirs = flag.Bool("prune-empty-dirs", false, "Remove any empty directories under --path once finished.")
+
+// Quick buckets files on their size and the CRC32 of their first 64KiB, without full hashing.
+var Quick = flag.Bool("quick", false, "Approximate: match files on size and a CRC32 of the first 64KiB only.")
+
+// EndsOnly hashes only the first and last BlockSize bytes of each file, with its size.
+var EndsOnly = flag.Bool("ends-only", false, "Approximate: match files on size and their first and last --block-size bytes only.")
+
+// BlockSize is how much of each end of a file --ends-only reads.
+var BlockSize = flag.Int64("block-size", 64*1024, "Bytes read from each end of a file by --ends-only.")
+
+// CDC splits files into content-defined chunks, also reporting files which share some of them.
+var CDC = flag.Bool("cdc", false, "Hash files as content-defined chunks, also logging pairs of files which share chunks (partial duplicates).")
+
+// TrustNameSize buckets files on their base name and size alone, without reading them.
+var TrustNameSize = flag.Bool("trust-name-size", false, "Unverified: match files on base name and size only, without reading them.")
+
+// TrustMtimeSize buckets files on their modification time and size alone, without reading them.
+var TrustMtimeSize = flag.Bool("trust-mtime-size", false, "Unverified: match files on modification time and size only, without reading them.")
+
+// Verify compares the files of each group byte by byte before reporting them.
+var Verify = flag.Bool("verify", false, "Compare the files of each group byte by byte, splitting any that differ.")
+
+// Retries is how many times to retry reading a file after a transient error.
+var Retries = flag.Int("retries", 0, "Number of times to retry a file after a transient read error.")
+
+// RetryDelay is how long to wait before the first retry; it doubles with each retry after.
+var RetryDelay = flag.Duration("retry-delay", 100*time.Millisecond, "Delay before the first retry, doubling for each retry after.")
+
+// PanicFatal lets a panic while hashing a file crash the program, for debugging.
+var PanicFatal = flag.Bool("panic-fatal", false, "Crash on a panic while hashing a file instead of skipping it (for debugging).")
+
+// SkipLocked quietly skips files which are locked or in use by another process.
+var SkipLocked = flag.Bool("skip-locked", false, "Quietly skip files locked or in use by other processes instead of reporting them as errors.")
+
+// TextNormalize hashes text files with normalized line endings and trailing whitespace.
+var TextNormalize = flag.Bool("text-normalize", false, "Normalize line endings and trailing whitespace of text files before hashing.")
+
+// TextExtensions limits --text-normalize to files with these extensions.
+var TextExtensions = flag.StringSlice("text-ext", nil, "Only --text-normalize files with these extensions (default: any file that looks like text).")
+
+// ContentsOnly leaves the size out of the key, so that files of different sizes can match.
+var ContentsOnly = flag.Bool("compare-contents-only", false, "Match files on their (normalized) content alone, even if their sizes differ.")
+
+// Excludes are glob patterns for files and directories to skip.
+var Excludes = flag.StringArray("exclude", nil, "Skip files and directories whose name or relative path match this glob (repeatable).")
+
+// SkipAppleMetadata skips the AppleDouble and .DS_Store files macOS leaves on other filesystems.
+var SkipAppleMetadata = flag.Bool("skip-apple-metadata", false, "Skip macOS metadata: AppleDouble ._* files, .AppleDouble directories and .DS_Store.")
+
+// NoHiddenDirDescent skips directories whose names start with a dot, but not such files.
+var NoHiddenDirDescent = flag.Bool("no-hidden-dir-descent", false, "Don't descend into hidden (dot) directories such as .git and .cache, while still hashing hidden files elsewhere.")
+
+// ExcludeFrom names a file of glob patterns to skip, one per line.
+var ExcludeFrom = flag.String("exclude-from", "", "Read --exclude patterns from this file, one per line ('#' comments allowed).")
+
+// SkipTypes are content types, such as image/*, of files to skip.
+var SkipTypes = flag.StringArray("skip-type", nil, "Skip files whose detected content type matches this glob, e.g. 'image/*' (repeatable).")
+
+// Keep is the strategy for choosing which file of each group to keep, which is listed first.
+var Keep = flag.String("keep", "first", "Which file of each group to keep (listed first): first, last, shortest, longest, oldest, newest.")
+
+// AnnotateActions tags each file in text listings with whether the actions would keep it.
+var AnnotateActions = flag.Bool("annotate-actions", false, "Prefix each file in text and fdupes listings with KEEP or DROP, as --keep would decide.")
+
+// Suggest notes which file of each group --keep would keep, and why.
+var Suggest = flag.Bool("suggest", false, "Note which file of each group to keep, and why, as --keep and --prefer-prefix would decide.")
+
+// DiskUsage notes the space each group takes up on disk, as well as by size.
+var DiskUsage = flag.Bool("disk-usage", false, "Note the space each group's files take up on disk and what removing the duplicates would free, and log the totals.")
+
+// FileSort orders the files within each group, after the one being kept.
+var FileSort = flag.String("file-sort", "", "Order the files within each group by path, mtime or size (default the order they were found).")
+
+// PreferPrefixes chooses the file to keep by path prefix, in order, before applying Keep.
+var PreferPrefixes = flag.StringArray("prefer-prefix", nil, "Keep the file under the earliest-listed matching path prefix (repeatable), falling back to --keep.")
+
+// ErrorsOutput names a file to list every file that couldn't be read in.
+var ErrorsOutput = flag.String("errors-output", "", "Write every file that couldn't be read, and why, to this file.")
+
+// Quiet stops errors about individual files being logged.
+var Quiet = flag.BoolP("quiet", "q", false, "Don't log errors about individual files.")
+
+// MaxOpenFiles bounds how many files the workers have open at once.
+var MaxOpenFiles = flag.Int("max-open-files", 0, "Most files to have open at once across all workers (0 for half the process limit).")
+
+// IncludeMode only matches files whose permission bits match as well as their content.
+var IncludeMode = flag.Bool("include-mode", false, "Only match files whose permissions also match.")
+
+// IncludeOwner only matches files whose uid and gid match as well as their content.
+var IncludeOwner = flag.Bool("include-owner", false, "Only match files whose owner and group also match (where supported).")
+
+// ExtensionSensitive only matches files whose extensions match as well as their content.
+var ExtensionSensitive = flag.Bool("extension-sensitive", false, "Only match files whose extensions (ignoring case) also match.")
+
+// Delete removes all but the kept file of each group.
+var Delete = flag.Bool("delete", false, "Delete all but the kept file of each group.")
+
+// Hardlink replaces all but the kept file of each group with a hard link to it.
+var Hardlink = flag.Bool("hardlink", false, "Replace all but the kept file of each group with a hard link to it.")
+
+// TreeHash logs a single hash of every file that was hashed, for comparing whole trees.
+var TreeHash = flag.Bool("tree-hash", false, "Log a single hash of the paths and contents of every file hashed, which two identical trees share.")
+
+// TreeHashContentOnly leaves the paths out of the tree hash.
+var TreeHashContentOnly = flag.Bool("tree-hash-content-only", false, "Leave the paths out of --tree-hash, so that only the contents have to match.")
+
+// LinkCheck reports the groups whose files span devices, which --hardlink can't link.
+var LinkCheck = flag.Bool("link-check", false, "Log the groups --hardlink can't fully link because their files are on different devices.")
+
+// MoveTo moves all but the kept file of each group into this directory.
+var MoveTo = flag.String("move-to", "", "Move all but the kept file of each group under this directory, keeping their relative paths.")
+
+// PlanOutput writes what the selected action would do to this file instead of doing it.
+var PlanOutput = flag.String("plan-output", "", "Write what --delete (or --hardlink/--move-to) would do to this JSON file instead of doing it.")
+
+// ApplyPlan carries out a plan written by --plan-output.
+var ApplyPlan = flag.String("apply-plan", "", "Carry out the plan in this file on the files that still match it, without scanning.")
+
+// DryRun reports what --delete, --hardlink or --move-to would do without doing it.
+var DryRun = flag.BoolP("dry-run", "n", false, "Show what --delete, --hardlink or --move-to would do without doing it.")
+
+// OnActionError decides what happens to the rest of a group when an action fails on one of
+// its files.
+var OnActionError = flag.String("on-action-error", "abort", "When an action fails on a file, abort the rest of its group (leaving them intact) or continue with them.")
+
+// Yes skips the confirmation before --delete, --hardlink or --move-to.
+var Yes = flag.BoolP("yes", "y", false, "Don't ask for confirmation before --delete, --hardlink or --move-to.")
+
+// GroupSeparator is written between groups in the text listing.
+var GroupSeparator = flag.String("group-separator", "\\n", "Written between groups in text listings (escapes such as \\n are interpreted).")
+
+// FileSeparator is written between the files of a group in the text listing.
+var FileSeparator = flag.String("file-separator", "\\n", "Written between the files of a group in text listings.")
+
+// SingleLine lists each group on a single line, the way findupe used to.
+var SingleLine = flag.Bool("single-line", false, "Use the old text listing layout of one group per line.")
+
+// HashNames notes whether the files in each group have the same or differing names.
+var HashNames = flag.Bool("hash-names", false, "Note whether each group's files were copied (same name) or renamed.")
+
+// GroupIDs gives each group a short id derived from its hash, for cross-referencing.
+var GroupIDs = flag.Bool("group-ids", false, "Give each group a short, stable id derived from its hash: group_id in json, yaml and plans, and a [group: id] line in text listings.")
+
+// FlagCaseVariants notes groups with files whose paths only differ in case.
+var FlagCaseVariants = flag.Bool("flag-case-variants", false, "Note groups with files whose paths (relative to their roots) only differ in case, as cross-OS copies do.")
+
+// CaseInsensitiveFS treats paths which differ only in case as the same file.
+var CaseInsensitiveFS = flag.Bool("case-insensitive-fs", false, "Treat paths differing only in case as the same file (as on macOS and Windows), so it isn't reported as its own duplicate.")
+
+// NormalizeUnicode compares file names in their composed (NFC) form.
+var NormalizeUnicode = flag.Bool("normalize-unicode", false, "Compare file names as Unicode NFC, so macOS (NFD) names match their copies elsewhere.")
+
+// Sparse fills in the holes of sparse files with zeros instead of reading them.
+var Sparse = flag.Bool("sparse", false, "Don't read the holes of sparse files, filling them in as zeros (Linux; hashes are unchanged).")
+
+// MaxReadRate limits the total rate at which files are read, in bytes per second.
+var MaxReadRate = flag.Int64("max-read-rate", 0, "Limit reads across all workers to this many bytes per second (0 for no limit).")
+
+// ProgressBar shows the progress of the scan on stderr.
+var ProgressBar = flag.Bool("progress-bar", false, "Show a progress bar on stderr (or periodic progress lines if it isn't a terminal).")
+
+// Benchmark measures the throughput of each hash algorithm instead of scanning.
+var Benchmark = flag.Bool("benchmark", false, "Measure how fast each hash algorithm is on --threads workers, instead of scanning.")
+
+// BenchmarkSize is the size of each file --benchmark hashes.
+var BenchmarkSize = flag.Int64("benchmark-size", 64*1024*1024, "Size of each of the files --benchmark writes and hashes, in bytes.")
+
+// BenchmarkDir is where --benchmark writes its files.
+var BenchmarkDir = flag.String("benchmark-dir", "", "Directory for --benchmark's temporary files (default the system temporary directory).")
+
+// Serve serves the duplicates over HTTP on this address once the scan is done.
+var Serve = flag.String("serve", "", "Once the scan is done, serve the duplicates as JSON over HTTP on this address (e.g. localhost:8080) until interrupted.")
+
+// SizeIndex names a list of file sizes and paths to use instead of walking the tree.
+var SizeIndex = flag.String("size-index", "", "Take the files and their sizes from this list of 'size path' lines (as find -printf '%s %p\\n' writes) instead of walking the roots.")
+
+// SizeIndexCache names a file to keep the listings of the directories walked in between runs.
+var SizeIndexCache = flag.String("size-index-cache", "", "Keep the listing of every directory walked in this file, and take it from there next time for directories whose modification time hasn't changed.")
+
+// SampleRate hashes only this fraction of the file sizes, to estimate what a full scan would find.
+var SampleRate = flag.Float64("sample-rate", 1, "Only hash the files of this fraction of sizes (say 0.1), and estimate the space a full scan would find from them.")
+
+// Spill keeps at most this many hashes in memory during aggregation, spilling the rest to disk.
+var Spill = flag.Int("spill", 0, "Keep at most this many hashes in memory, sorting the rest into temporary files (0 to keep them all in memory).")
+
+// SpillDir is where --spill writes its temporary files.
+var SpillDir = flag.String("spill-dir", "", "Directory for --spill's temporary files (default the system temporary directory).")
\ No newline at end of file
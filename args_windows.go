@@ -0,0 +1,10 @@
+package main
+
+// Windows-only command line arguments.
+
+import (
+	flag "github.com/spf13/pflag"
+)
+
+// IncludeADS folds a file's alternate data streams into its hash.
+var IncludeADS = flag.Bool("include-ads", false, "Include NTFS alternate data streams in each file's hash.")
@@ -0,0 +1,42 @@
+package main
+
+// Choosing --threads from the storage being scanned, for --auto-threads.
+//
+// Solid-state drives answer many requests at once, and get faster the deeper their queue, while
+// a spinning disk has one head that every extra worker sends seeking back and forth. Where the
+// kind of storage can be found out (Linux, from sysfs), SSDs and NVMe get twice as many workers
+// as there are CPUs and spinning disks get two; anywhere else, or if it can't be told, there is
+// one worker per CPU.
+
+import (
+	"log"
+	"runtime"
+)
+
+
+// rotationalThreads is how many workers a spinning disk gets.
+const rotationalThreads = 2
+
+
+// autoThreads picks the number of workers for the storage the roots are on; any spinning disk
+// among them sets the pace for the lot.
+func autoThreads() int {
+	solid := true
+	for _, root := range roots {
+		rotational, known := isRotational(root)
+		if !known {
+			solid = false
+			continue
+		}
+		if rotational {
+			log.Print("Auto threads: ", rotationalThreads, " (", root, " is on a rotational disk)")
+			return rotationalThreads
+		}
+	}
+	if solid {
+		log.Print("Auto threads: ", runtime.NumCPU()*2, " (solid-state storage)")
+		return runtime.NumCPU() * 2
+	}
+	log.Print("Auto threads: ", runtime.NumCPU(), " (storage type unknown)")
+	return runtime.NumCPU()
+}
@@ -0,0 +1,120 @@
+package main
+
+// Measuring hashing throughput, for --benchmark.
+//
+// A set of files of random data is written to a temporary directory, and each one is hashed
+// with every algorithm in turn on --threads workers, the same way a scan would hash them, to
+// show which algorithm and how many threads suit the machine. The files were only just
+// written, so they are read from the page cache and what is measured is the hashing, not the
+// disk. The files are removed afterwards.
+
+import (
+	"fmt"
+	"hash"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+
+// writeBenchmarkFiles writes count files of size bytes of random data to dir.
+func writeBenchmarkFiles(dir string, count int, size int64) ([]string, error) {
+	source := rand.New(rand.NewSource(1))
+	block := make([]byte, 1024*1024)
+	source.Read(block)
+
+	paths := make([]string, count)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("bench-%03d", i))
+		file, err := os.Create(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		for written := int64(0); written < size; {
+			chunk := block
+			if size-written < int64(len(chunk)) {
+				chunk = chunk[:size-written]
+			}
+			n, err := file.Write(chunk)
+			written += int64(n)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+		if err := file.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+
+// benchmarkAlgorithm hashes every file with an algorithm on --threads workers, returning how
+// long it took.
+func benchmarkAlgorithm(paths []string, newHash func() hash.Hash) (time.Duration, error) {
+	queue := make(chan string, len(paths))
+	for _, path := range paths {
+		queue <- path
+	}
+	close(queue)
+
+	var workerGroup sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	start := time.Now()
+	workerGroup.Add(*Threads)
+	for i := 0; i < *Threads; i++ {
+		go func() {
+			defer workerGroup.Done()
+			for path := range queue {
+				if _, err := hashData(path, newHash()); err != nil {
+					errOnce.Do(func() { firstErr = err })
+				}
+			}
+		}()
+	}
+	workerGroup.Wait()
+	return time.Since(start), firstErr
+}
+
+
+// runBenchmark prints the throughput of every algorithm.
+func runBenchmark() error {
+	dir, err := os.MkdirTemp(*BenchmarkDir, "findupe-bench-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	count := *Threads * 2
+	paths, err := writeBenchmarkFiles(dir, count, *BenchmarkSize)
+	if err != nil {
+		return err
+	}
+
+	candidates := make(map[string]func() hash.Hash, len(algorithms)+1)
+	for name, newHash := range algorithms {
+		candidates[name] = newHash
+	}
+	candidates["blake2b"] = newBlake2b
+	names := make([]string, 0, len(candidates))
+	for name := range candidates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	total := float64(*BenchmarkSize) * float64(count)
+	fmt.Printf("Hashing %d files of %s on %d threads:\n", count, formatBytes(*BenchmarkSize), *Threads)
+	for _, name := range names {
+		elapsed, err := benchmarkAlgorithm(paths, candidates[name])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  %-8s %10.1f MB/s\n", name, total/elapsed.Seconds()/1e6)
+	}
+	return nil
+}
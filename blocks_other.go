@@ -0,0 +1,12 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "os"
+
+
+// allocatedBytes returns the size of a file, as there is no block count to go by here.
+func allocatedBytes(info os.FileInfo) int64 {
+	return info.Size()
+}
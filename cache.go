@@ -0,0 +1,207 @@
+package main
+
+// The hash cache.
+//
+// With --cache, the hash of every fully-hashed file is written to a JSON Lines file at the end
+// of the run. With --since-last-run as well, the cache is loaded first and any file whose size
+// and modification time still match its cached record is given the cached hash instead of
+// being read again. Only files seen during this run are written back, so the records of files
+// that have since been deleted are pruned - unless the run was interrupted, in which case the
+// records of the files it didn't get to are carried forward as they were.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+
+// CacheRecord is a single file's entry in the cache.
+type CacheRecord struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"hash"`
+}
+
+
+// cacheHeader is the first line of a cache file, identifying the options the hashes were
+// produced with.
+type cacheHeader struct {
+	Version  int    `json:"findupe-cache"`
+	Settings string `json:"settings"`
+}
+
+
+// cachedHashes is the cache as loaded at startup, keyed by path.
+var cachedHashes map[string]*CacheRecord
+
+// freshHashes collects the records to be written back to the cache.
+var freshHashes map[string]*CacheRecord
+
+// reusedFiles counts files whose hash came from the cache.
+var reusedFiles int64
+
+
+// cacheSettings describes the options which affect the hash of a file, so that a cache built
+// with different options isn't used.
+func cacheSettings() string {
+	return fmt.Sprintf("thorough=%v offset=%d length=%d quick=%v trust-name-size=%v trust-mtime-size=%v text-normalize=%v text-ext=%v contents-only=%v algo-map=%s archive-aware=%v ends-only=%v normalize-unicode=%v cdc=%v multi-algo=%v",
+		*Thorough, *Offset, *Length, *Quick, *TrustNameSize, *TrustMtimeSize, *TextNormalize, *TextExtensions, *ContentsOnly, *AlgoMap, *ArchiveAware,
+		endsSetting(), *NormalizeUnicode, *CDC, *MultiAlgo) + streamsSettings()
+}
+
+
+// endsSetting describes --ends-only for cacheSettings, including the block size only when it
+// is in use.
+func endsSetting() string {
+	if !*EndsOnly {
+		return "false"
+	}
+	return fmt.Sprintf("%d", *BlockSize)
+}
+
+
+// loadCache reads the cache file, if there is one, into cachedHashes.
+func loadCache(filename string) error {
+	cachedHashes = make(map[string]*CacheRecord)
+
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+	var header cacheHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Version != 1 {
+		return fmt.Errorf("%s is not a findupe cache", filename)
+	}
+	if header.Settings != cacheSettings() {
+		log.Printf("cache %s was built with different options (%s), ignoring it", filename, header.Settings)
+		return nil
+	}
+
+	for scanner.Scan() {
+		record := &CacheRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			log.Printf("skipping malformed cache line in %s: %s", filename, err.Error())
+			continue
+		}
+		cachedHashes[record.Path] = record
+	}
+
+	return scanner.Err()
+}
+
+
+// lookupCache returns the cached hash for a request if the file is unchanged since it was
+// cached, or an empty string.
+func lookupCache(request *FileHash) string {
+	record, ok := cachedHashes[request.Pathname]
+	if !ok {
+		return ""
+	}
+	if record.Size != request.Size || !record.ModTime.Equal(request.ModTime) {
+		return ""
+	}
+	reusedFiles++
+	return record.Hash
+}
+
+
+// cacheTap records every reply passing through it for the cache, forwarding them on to the
+// returned channel.
+func cacheTap(replies <-chan *FileHash) <-chan *FileHash {
+	tapped := make(chan *FileHash, cap(replies))
+	freshHashes = make(map[string]*CacheRecord)
+
+	go func() {
+		defer close(tapped)
+		for reply := range replies {
+			freshHashes[reply.Pathname] = &CacheRecord{
+				Path:    reply.Pathname,
+				Size:    reply.Size,
+				ModTime: reply.ModTime,
+				// Metadata is cheap to get and isn't covered by the modification time.
+				Hash: strings.TrimSuffix(reply.Hash, metadataKey(reply)),
+			}
+			tapped <- reply
+		}
+	}()
+
+	return tapped
+}
+
+
+// saveCache writes the records collected by cacheTap to the cache file, via a temporary file
+// so that an interrupted write doesn't destroy the previous cache.
+func saveCache(filename string) error {
+	if isInterrupted() && cachedHashes == nil {
+		// Without --since-last-run the old cache wasn't loaded, but its records are still
+		// needed for the files this run didn't reach.
+		if err := loadCache(filename); err != nil {
+			return err
+		}
+	}
+
+	temporary := filename + ".tmp"
+	file, err := os.Create(temporary)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	encoder.Encode(cacheHeader{Version: 1, Settings: cacheSettings()})
+	for _, record := range freshHashes {
+		if err := encoder.Encode(record); err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	// A file that wasn't seen has been deleted, unless the run stopped before reaching it.
+	unseen := 0
+	for path, record := range cachedHashes {
+		if _, ok := freshHashes[path]; ok {
+			continue
+		}
+		unseen++
+		if isInterrupted() {
+			if err := encoder.Encode(record); err != nil {
+				file.Close()
+				return err
+			}
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if isInterrupted() {
+		log.Print("Cache: ", len(freshHashes)+unseen, " records, reused:", reusedFiles, ", carried forward:", unseen)
+	} else {
+		log.Print("Cache: ", len(freshHashes), " records, reused:", reusedFiles, ", pruned:", unseen)
+	}
+
+	return os.Rename(temporary, filename)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+
+// resetInterrupt undoes interrupt, so that one test's interruption doesn't stop the next.
+func resetInterrupt() {
+	atomic.StoreInt32(&interrupted, 0)
+	interruptCh = make(chan struct{})
+}
+
+
+// useFileSystem has a test read from fsys instead of the disk.
+func useFileSystem(t *testing.T, fsys FileSystem) {
+	previous := fileSystem
+	fileSystem = fsys
+	t.Cleanup(func() { fileSystem = previous })
+}
+
+
+// readCacheFile reads a cache file back as its header and records.
+func readCacheFile(t *testing.T, filename string) (cacheHeader, map[string]*CacheRecord) {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var header cacheHeader
+	records := make(map[string]*CacheRecord)
+	scanner := bufio.NewScanner(file)
+	for line := 0; scanner.Scan(); line++ {
+		if line == 0 {
+			if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+				t.Fatalf("header: %s", err)
+			}
+			continue
+		}
+		record := &CacheRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			t.Fatalf("line %d: %s", line+1, err)
+		}
+		records[record.Path] = record
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return header, records
+}
+
+
+func TestSaveCacheAfterInterrupt(t *testing.T) {
+	mtime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	useFileSystem(t, NewIOFileSystem(fstest.MapFS{
+		"tree/a.txt": {Data: []byte("first"), ModTime: mtime},
+		"tree/b.txt": {Data: []byte("second"), ModTime: mtime},
+		"tree/c.txt": {Data: []byte("third"), ModTime: mtime},
+	}))
+
+	threads := *Threads
+	*Threads = 1
+	defer func() { *Threads = threads }()
+	defer resetInterrupt()
+
+	// The previous run cached all three files, and a fourth since deleted.
+	cachedHashes = make(map[string]*CacheRecord)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt", "gone.txt"} {
+		path := filepath.Join("tree", name)
+		cachedHashes[path] = &CacheRecord{Path: path, Size: 5, ModTime: mtime, Hash: "old:" + name}
+	}
+	defer func() { cachedHashes, freshHashes = nil, nil }()
+
+	// Interrupt the run once the first file has been hashed.
+	hasher := selectedHasher()
+	interrupting := func(request *FileHash) *FileHash {
+		reply := hasher(request)
+		interrupt()
+		return reply
+	}
+
+	requests, replies := make(chan *FileHash, 3), make(chan *FileHash, 3)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		requests <- &FileHash{Pathname: filepath.Join("tree", name), Size: 5, ModTime: mtime}
+	}
+	close(requests)
+	go workers(requests, replies, interrupting)
+
+	hashed := 0
+	for range cacheTap(replies) {
+		hashed++
+	}
+	if hashed != 1 {
+		t.Fatalf("%d files hashed after the interrupt, want 1", hashed)
+	}
+
+	filename := filepath.Join(t.TempDir(), "cache.jsonl")
+	if err := saveCache(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	header, records := readCacheFile(t, filename)
+	if header.Version != 1 || header.Settings != cacheSettings() {
+		t.Errorf("header %+v", header)
+	}
+	if len(records) != 4 {
+		t.Errorf("%d records, want 4", len(records))
+	}
+	if record := records[filepath.Join("tree", "a.txt")]; record == nil || record.Hash == "old:a.txt" {
+		t.Errorf("a.txt was hashed, but its record is %+v", record)
+	}
+	for _, name := range []string{"b.txt", "c.txt", "gone.txt"} {
+		if record := records[filepath.Join("tree", name)]; record == nil || record.Hash != "old:"+name {
+			t.Errorf("%s wasn't reached, so its record should be carried forward, but it is %+v", name, record)
+		}
+	}
+}
+
+
+func TestSaveCachePrunes(t *testing.T) {
+	mtime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	cachedHashes = map[string]*CacheRecord{
+		"kept":    {Path: "kept", Size: 1, ModTime: mtime, Hash: "old"},
+		"deleted": {Path: "deleted", Size: 1, ModTime: mtime, Hash: "old"},
+	}
+	freshHashes = map[string]*CacheRecord{
+		"kept": {Path: "kept", Size: 1, ModTime: mtime, Hash: "new"},
+	}
+	defer func() { cachedHashes, freshHashes = nil, nil }()
+
+	filename := filepath.Join(t.TempDir(), "cache.jsonl")
+	if err := saveCache(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	_, records := readCacheFile(t, filename)
+	if len(records) != 1 || records["kept"] == nil || records["kept"].Hash != "new" {
+		t.Errorf("records %v, want only the fresh one", records)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+// The breakdown of duplicates by kind of content, for --by-category.
+//
+// Each group is put in a category by the extension most of its files share, or failing that by
+// the content type detected from the file being kept, and the groups, duplicate files and
+// reclaimable bytes of each category are totalled.
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+
+// categories are the categories in the order they are logged.
+var categories = []string{"images", "videos", "audio", "documents", "code", "other"}
+
+
+// extensionCategories maps lower-case extensions, without the dot, to their category.
+var extensionCategories = func() map[string]string {
+	lists := map[string]string{
+		"images":    "jpg jpeg png gif bmp tif tiff webp heic heif raw cr2 nef arw dng svg psd ico",
+		"videos":    "mp4 m4v mkv mov avi wmv flv webm mpg mpeg m2ts mts 3gp",
+		"audio":     "mp3 m4a aac flac wav ogg opus wma aiff alac",
+		"documents": "pdf doc docx xls xlsx ppt pptx odt ods odp rtf txt md epub csv tex pages numbers key",
+		"code":      "go c h cc cpp hpp cs java kt py rb js ts jsx tsx rs swift php pl sh bat ps1 lua sql html css scss json yaml yml toml xml",
+	}
+	table := make(map[string]string)
+	for category, list := range lists {
+		for _, ext := range strings.Fields(list) {
+			table[ext] = category
+		}
+	}
+	return table
+}()
+
+
+// typeCategories maps the prefixes of detected content types to their category.
+var typeCategories = []struct{ prefix, category string }{
+	{"image/", "images"},
+	{"video/", "videos"},
+	{"audio/", "audio"},
+	{"application/pdf", "documents"},
+	{"text/", "documents"},
+}
+
+
+// groupCategory picks the category of a group.
+func groupCategory(group Group) string {
+	counts := make(map[string]int)
+	best := ""
+	for _, file := range group.Files {
+		ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), ".")
+		if category, ok := extensionCategories[ext]; ok {
+			counts[category]++
+			if best == "" || counts[category] > counts[best] {
+				best = category
+			}
+		}
+	}
+	if best != "" && counts[best]*2 > len(group.Files) {
+		return best
+	}
+
+	detected := contentType(group.Files[0])
+	for _, rule := range typeCategories {
+		if strings.HasPrefix(detected, rule.prefix) {
+			return rule.category
+		}
+	}
+	if best != "" {
+		return best
+	}
+	return "other"
+}
+
+
+// logCategories logs the groups, duplicates and reclaimable bytes of each category.
+func logCategories(collisions CollisionTable) {
+	type totals struct {
+		groups, files int64
+		bytes         int64
+	}
+
+	byCategory := make(map[string]*totals)
+	var all totals
+	for _, group := range sortedGroups(collisions) {
+		category := groupCategory(group)
+		if byCategory[category] == nil {
+			byCategory[category] = &totals{}
+		}
+		for _, t := range []*totals{byCategory[category], &all} {
+			t.groups++
+			t.files += int64(group.Count() - 1)
+			t.bytes += group.WastedBytes()
+		}
+	}
+
+	log.Print("Duplicates by category:")
+	for _, category := range categories {
+		if t := byCategory[category]; t != nil {
+			log.Printf("  %-10s %8s groups %8s duplicates %10s", category, formatCount(t.groups), formatCount(t.files), formatBytes(t.bytes))
+		}
+	}
+	log.Printf("  %-10s %8s groups %8s duplicates %10s", "total", formatCount(all.groups), formatCount(all.files), formatBytes(all.bytes))
+}
@@ -0,0 +1,265 @@
+package main
+
+// Content-defined chunking, for --cdc.
+//
+// Each file is cut into chunks wherever a rolling (gear) hash of the last few bytes meets a
+// mask, so that the cut points move with the content rather than sitting at fixed offsets: an
+// insertion near the start of a file only changes the chunks around it, as in restic and borg.
+// Files are still keyed on the hash of all of their chunks, which finds exact duplicates as
+// usual, and the chunks of every file are indexed on the way past so that pairs of files which
+// share some chunks without being identical can be reported as partial duplicates.
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+
+// The chunk sizes; below the average the mask is harder to meet, and above it easier, to keep
+// most chunks near the average.
+const (
+	cdcMinChunk = 2 * 1024
+	cdcAvgChunk = 8 * 1024
+	cdcMaxChunk = 64 * 1024
+)
+
+// cdcMaskSmall and cdcMaskLarge are the masks used before and after the average chunk size,
+// taken from the top bits of the hash, which have seen the most bytes.
+const (
+	cdcMaskSmall = uint64(1<<15-1) << (64 - 15)
+	cdcMaskLarge = uint64(1<<11-1) << (64 - 11)
+)
+
+// cdcFanout is the most files a chunk can appear in and still count towards the similarity of
+// files; chunks that common (runs of zeros, standard headers) say nothing about them.
+const cdcFanout = 64
+
+
+// gearTable gives every byte value a fixed pseudo-random contribution to the rolling hash.
+var gearTable = func() (table [256]uint64) {
+	// splitmix64, so that the table is the same on every run and every machine.
+	seed := uint64(0x6a09e667f3bcc908)
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		value := seed
+		value = (value ^ (value >> 30)) * 0xbf58476d1ce4e5b9
+		value = (value ^ (value >> 27)) * 0x94d049bb133111eb
+		table[i] = value ^ (value >> 31)
+	}
+	return
+}()
+
+
+// Chunk is one content-defined chunk of a file.
+type Chunk struct {
+	// Digest is the first eight bytes of the chunk's sha256, which is plenty to index it by.
+	Digest uint64
+	// Size is the length of the chunk in bytes.
+	Size int64
+}
+
+
+// splitChunks cuts the content of a reader into chunks, passing each one to emit; the slice is
+// reused, so emit mustn't keep it.
+func splitChunks(reader io.Reader, emit func(chunk []byte)) error {
+	chunk := make([]byte, 0, cdcMaxChunk)
+	buffer := make([]byte, 64*1024)
+	var rolling uint64
+	for {
+		n, err := reader.Read(buffer)
+		for _, b := range buffer[:n] {
+			chunk = append(chunk, b)
+			if len(chunk) < cdcMinChunk {
+				continue
+			}
+			rolling = (rolling << 1) + gearTable[b]
+			mask := cdcMaskSmall
+			if len(chunk) >= cdcAvgChunk {
+				mask = cdcMaskLarge
+			}
+			if rolling&mask == 0 || len(chunk) >= cdcMaxChunk {
+				emit(chunk)
+				chunk, rolling = chunk[:0], 0
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(chunk) > 0 {
+		emit(chunk)
+	}
+	return nil
+}
+
+
+// hashChunks splits a file into chunks, returning the hash of the whole file, made from the
+// hashes of its chunks, along with the chunks themselves.
+func hashChunks(pathname string) (string, []Chunk, error) {
+	file, reader, err := openContent(pathname)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	hasher := sha512.New()
+	var chunks []Chunk
+	err = splitChunks(reader, func(chunk []byte) {
+		digest := sha256.Sum256(chunk)
+		hasher.Write(digest[:])
+		chunks = append(chunks, Chunk{Digest: binary.BigEndian.Uint64(digest[:8]), Size: int64(len(chunk))})
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), chunks, nil
+}
+
+
+// cdcRequest keys a request on its size and the hash of its chunks, keeping the chunks for
+// the partial duplicate report.
+func cdcRequest(request *FileHash) *FileHash {
+	if request.IsLink {
+		return hashRequest(request)
+	}
+
+	pathname := strings.ReplaceAll(request.Pathname, "\\", "/")
+	var chunks []Chunk
+	hashString, err := withRetries(func() (hashString string, err error) {
+		hashString, chunks, err = hashChunks(pathname)
+		return hashString, err
+	})
+	if err != nil {
+		readError(pathname, err)
+		return nil
+	}
+
+	request.Pathname = pathname
+	request.Hash = sizeKey(request.Size) + "cdc." + hashString + metadataKey(request)
+	request.Chunks = chunks
+
+	return request
+}
+
+
+// cdcFiles and cdcSizes are the paths and sizes of the files whose chunks have been indexed,
+// and cdcIndex the indexes into them of the files each chunk was seen in; only the tap
+// updates them.
+var cdcFiles []string
+var cdcSizes []int64
+var cdcIndex = make(map[Chunk][]int32)
+
+
+// cdcTap indexes the chunks of every reply passing through it, forwarding them on to the
+// returned channel without their chunks.
+func cdcTap(replies <-chan *FileHash) <-chan *FileHash {
+	tapped := make(chan *FileHash, cap(replies))
+
+	go func() {
+		defer close(tapped)
+		for reply := range replies {
+			file := int32(len(cdcFiles))
+			cdcFiles = append(cdcFiles, reply.Pathname)
+			cdcSizes = append(cdcSizes, reply.Size)
+			for _, chunk := range reply.Chunks {
+				// Count each chunk once per file, however often it repeats in it.
+				if seen := cdcIndex[chunk]; len(seen) == 0 || seen[len(seen)-1] != file {
+					cdcIndex[chunk] = append(seen, file)
+				}
+			}
+			reply.Chunks = nil
+			tapped <- reply
+		}
+	}()
+
+	return tapped
+}
+
+
+// partialPair is two files which share some of their chunks.
+type partialPair struct {
+	first, second int32
+	shared        int64
+}
+
+
+// partialDuplicates returns the pairs of files which share chunks but aren't in the same
+// group, sharing the most bytes first. Each group of exact duplicates is represented by its
+// first file alone, so that a file sharing chunks with the group is only listed once.
+func partialDuplicates(collisions CollisionTable) []partialPair {
+	groupOf, represents := make(map[string]string), make(map[string]bool)
+	for hash, files := range collisions {
+		first := files[0]
+		for _, file := range files {
+			groupOf[file] = hash
+			if file < first {
+				first = file
+			}
+		}
+		represents[first] = true
+	}
+
+	shared := make(map[[2]int32]int64)
+	for chunk, files := range cdcIndex {
+		if len(files) < 2 || len(files) > cdcFanout {
+			continue
+		}
+		for i, first := range files {
+			for _, second := range files[i+1:] {
+				shared[[2]int32{first, second}] += chunk.Size
+			}
+		}
+	}
+
+	pairs := make([]partialPair, 0, len(shared))
+	for files, bytes := range shared {
+		first, second := cdcFiles[files[0]], cdcFiles[files[1]]
+		if hash, ok := groupOf[first]; ok && (groupOf[second] == hash || !represents[first]) {
+			continue
+		}
+		if _, ok := groupOf[second]; ok && !represents[second] {
+			continue
+		}
+		if bytes >= *MinDupeBytes {
+			pairs = append(pairs, partialPair{first: files[0], second: files[1], shared: bytes})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].shared != pairs[j].shared {
+			return pairs[i].shared > pairs[j].shared
+		}
+		if cdcFiles[pairs[i].first] != cdcFiles[pairs[j].first] {
+			return cdcFiles[pairs[i].first] < cdcFiles[pairs[j].first]
+		}
+		return cdcFiles[pairs[i].second] < cdcFiles[pairs[j].second]
+	})
+	return pairs
+}
+
+
+// logPartialDuplicates logs the pairs of files which share chunks without being duplicates,
+// with how much they share as a share of the larger of the two.
+func logPartialDuplicates(collisions CollisionTable) {
+	pairs := partialDuplicates(collisions)
+	log.Print("Partial duplicates: ", len(pairs), " pairs of files sharing chunks")
+	for _, pair := range pairs {
+		larger := cdcSizes[pair.first]
+		if cdcSizes[pair.second] > larger {
+			larger = cdcSizes[pair.second]
+		}
+		percent := 100.0
+		if larger > 0 {
+			percent = float64(pair.shared) * 100 / float64(larger)
+		}
+		log.Printf("  %5.1f%% %10s  %q %q", percent, formatBytes(pair.shared), cdcFiles[pair.first], cdcFiles[pair.second])
+	}
+}
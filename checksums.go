@@ -0,0 +1,203 @@
+package main
+
+// Checking a tree against a checksum manifest, for --verify-checksums.
+//
+// The manifest is in the format written by sha512sum and friends: a hex digest, two spaces (or
+// a space and a '*' for binary mode) and the path. Every listed file is hashed by the usual
+// workers, and each one whose hash differs, or which no longer exists, is reported, along with
+// any file under the roots which the manifest doesn't list. The algorithm is told apart by the
+// length of the digests.
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+
+// digestAlgorithms are the algorithms a manifest's digests can be, by their length in hex.
+var digestAlgorithms = map[int]string{
+	8:   "crc32",
+	32:  "md5",
+	40:  "sha1",
+	64:  "sha256",
+	128: "sha512",
+}
+
+
+// checksumAlgorithm is the algorithm the manifest was written with.
+var checksumAlgorithm string
+
+
+// unescapeChecksumPath undoes the escaping coreutils applies to names containing a backslash
+// or a newline, which it marks by starting the line with a backslash.
+func unescapeChecksumPath(path string) string {
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(path)
+}
+
+
+// parseChecksums reads a manifest into a map of cleaned path to digest, setting
+// checksumAlgorithm from the length of the digests.
+func parseChecksums(manifest string) (map[string]string, error) {
+	file, err := os.Open(manifest)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	digests := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		escaped := strings.HasPrefix(line, `\`)
+		if escaped {
+			line = line[1:]
+		}
+		space := strings.IndexByte(line, ' ')
+		if space < 0 || space+2 > len(line) || (line[space+1] != ' ' && line[space+1] != '*') {
+			return nil, fmt.Errorf("%s:%d: expected a digest, two spaces and a path", manifest, lineNo)
+		}
+		digest, path := strings.ToLower(line[:space]), line[space+2:]
+		if escaped {
+			path = unescapeChecksumPath(path)
+		}
+
+		algorithm, ok := digestAlgorithms[len(digest)]
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: can't tell the algorithm of a %d digit digest", manifest, lineNo, len(digest))
+		}
+		if checksumAlgorithm == "" {
+			checksumAlgorithm = algorithm
+		} else if algorithm != checksumAlgorithm {
+			return nil, fmt.Errorf("%s:%d: %s digest in a %s manifest", manifest, lineNo, algorithm, checksumAlgorithm)
+		}
+
+		digests[filepath.Clean(path)] = digest
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return digests, nil
+}
+
+
+// checksumRequest hashes a request's file with the manifest's algorithm.
+func checksumRequest(request *FileHash) *FileHash {
+	hashString, err := withRetries(func() (string, error) {
+		return hashData(request.Pathname, algorithms[checksumAlgorithm]())
+	})
+	if err != nil {
+		readError(request.Pathname, err)
+		return nil
+	}
+	request.Hash = hashString
+	return request
+}
+
+
+// extraFiles lists the regular files under the roots which aren't in the manifest, other than
+// the manifest itself and anything this run is writing.
+func extraFiles(digests map[string]string, manifest string) []string {
+	var extras []string
+	for _, root := range roots {
+		fileSystem.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info == nil {
+				return nil
+			}
+			if (isExcluded(path) && !isRoot(path)) || isOwnFile(path, info) || (info.IsDir() && isHiddenDir(path)) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() || !info.Mode().IsRegular() {
+				return nil
+			}
+			path = filepath.Clean(path)
+			if _, listed := digests[path]; !listed && path != filepath.Clean(manifest) {
+				extras = append(extras, path)
+			}
+			return nil
+		})
+	}
+	sort.Strings(extras)
+	return extras
+}
+
+
+// verifyChecksums checks the files listed in a manifest, printing each problem, and returns
+// whether there were none.
+func verifyChecksums(manifest string) (bool, error) {
+	digests, err := parseChecksums(manifest)
+	if err != nil {
+		return false, err
+	}
+
+	paths := make([]string, 0, len(digests))
+	for path := range digests {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var missing []string
+	requests := make([]*FileHash, 0, len(paths))
+	for _, path := range paths {
+		info, err := fileSystem.Stat(path)
+		if err != nil {
+			missing = append(missing, path)
+			continue
+		}
+		requests = append(requests, &FileHash{Pathname: path, Size: info.Size(), ModTime: info.ModTime()})
+	}
+
+	hashReqCh, hashRepCh = make(chan *FileHash, *Threads*2), make(chan *FileHash, *Threads*2)
+	go func() {
+		defer close(hashReqCh)
+		for _, request := range requests {
+			hashReqCh <- request
+		}
+	}()
+	go workers(hashReqCh, hashRepCh, checksumRequest)
+
+	hashed := make(map[string]bool, len(requests))
+	var failed []string
+	for reply := range hashRepCh {
+		hashed[reply.Pathname] = true
+		if reply.Hash != digests[reply.Pathname] {
+			failed = append(failed, reply.Pathname)
+		}
+	}
+	sort.Strings(failed)
+
+	var unreadable int
+	for _, request := range requests {
+		if !hashed[request.Pathname] {
+			unreadable++
+		}
+	}
+	extras := extraFiles(digests, manifest)
+
+	for _, path := range failed {
+		fmt.Printf("%s: FAILED\n", path)
+	}
+	for _, path := range missing {
+		fmt.Printf("%s: MISSING\n", path)
+	}
+	for _, path := range extras {
+		fmt.Printf("%s: EXTRA\n", path)
+	}
+
+	log.Printf("Checked %d %s digests: %d matched, %d failed, %d missing, %d unreadable, %d extra files",
+		len(digests), checksumAlgorithm, len(hashed)-len(failed), len(failed), len(missing), unreadable, len(extras))
+
+	return len(failed)+len(missing)+unreadable+len(extras) == 0, nil
+}
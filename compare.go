@@ -0,0 +1,68 @@
+package main
+
+// Comparing two files directly.
+//
+// Given exactly two paths which are files rather than directories, findupe hashes the pair
+// the same way it would hash them during a scan, with whatever options apply, and says whether
+// they match instead of walking anything, so it can be used like cmp in scripts: the exit
+// status is 0 if they are the same, 1 if they differ and 2 if either couldn't be read. With
+// --verify, a match is then confirmed byte by byte.
+
+import (
+	"fmt"
+)
+
+
+// twoFiles returns the roots if there are exactly two of them and neither is a directory;
+// one that doesn't exist is left for compareTwoFiles to report.
+func twoFiles() (string, string, bool) {
+	if len(roots) != 2 {
+		return "", "", false
+	}
+	for _, root := range roots {
+		if info, err := fileSystem.Stat(root); err == nil && info.IsDir() {
+			return "", "", false
+		}
+	}
+	return roots[0], roots[1], true
+}
+
+
+// compareTwoFiles hashes two files, printing and returning whether they match.
+func compareTwoFiles(first, second string) (bool, error) {
+	hasher := selectedHasher()
+	var hashes [2]string
+	for i, path := range []string{first, second} {
+		info, err := fileSystem.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		if !info.Mode().IsRegular() {
+			return false, fmt.Errorf("%s is not a regular file", path)
+		}
+		request := &FileHash{Pathname: path, Size: info.Size(), ModTime: info.ModTime(), Mode: info.Mode().Perm()}
+		if *IncludeOwner {
+			request.Owner = fileOwner(info)
+		}
+		reply := safeHash(hasher, request)
+		if reply == nil {
+			return false, fmt.Errorf("couldn't hash %s", path)
+		}
+		hashes[i] = reply.Hash
+	}
+
+	same := hashes[0] == hashes[1]
+	if same && *Verify {
+		var err error
+		if same, err = sameContent(first, second); err != nil {
+			return false, err
+		}
+	}
+
+	verdict := "differ"
+	if same {
+		verdict = "are identical"
+	}
+	fmt.Printf("%q and %q %s\n", first, second, verdict)
+	return same, nil
+}
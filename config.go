@@ -0,0 +1,199 @@
+package main
+
+// The config file, for defaults.
+//
+// --config names a file, or ~/.findupe.toml is used if it exists, of "name = value" lines
+// setting the default of the option with that long name. Values are TOML-style: "quoted" or
+// 'literal' strings, numbers, true or false, or a [list, of, values] for options that can be
+// repeated. Blank lines and '#' comments are ignored. Options given on the command line take
+// precedence over the config file, which takes precedence over the built-in defaults.
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+
+// defaultConfig is the config file used when --config isn't given, relative to the home
+// directory.
+const defaultConfig = ".findupe.toml"
+
+
+// configFile returns the config file to use, or an empty string if there isn't one.
+func configFile() string {
+	if flag.CommandLine.Changed("config") {
+		return *Config
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	filename := filepath.Join(home, defaultConfig)
+	if _, err := os.Stat(filename); err != nil {
+		return ""
+	}
+	return filename
+}
+
+
+// loadConfig applies the settings in the config file to any options which weren't given on
+// the command line.
+func loadConfig() error {
+	filename := configFile()
+	if filename == "" {
+		return nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		equals := strings.IndexByte(text, '=')
+		if equals < 0 {
+			return fmt.Errorf("%s:%d: expected name = value", filename, line)
+		}
+		name, value := strings.TrimSpace(text[:equals]), text[equals+1:]
+		option := flag.CommandLine.Lookup(name)
+		if option == nil || name == "config" {
+			return fmt.Errorf("%s:%d: unknown option %q", filename, line, name)
+		}
+
+		values, err := configValues(strings.TrimSpace(stripComment(value)))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", filename, line, name, err)
+		}
+		if option.Changed {
+			continue
+		}
+		for _, value := range values {
+			if err := option.Value.Set(value); err != nil {
+				return fmt.Errorf("%s:%d: %s: %w", filename, line, name, err)
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+
+// configValues parses a value from the config file into the strings to set the option to.
+func configValues(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") {
+		single, err := configValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return []string{single}, nil
+	}
+	if !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("unterminated list")
+	}
+
+	var values []string
+	for _, item := range splitList(value[1 : len(value)-1]) {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		single, err := configValue(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, single)
+	}
+	return values, nil
+}
+
+
+// configValue parses a single value, unquoting strings.
+func configValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := closingQuote(value)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		return value[1 : end+1], nil
+	}
+	return value, nil
+}
+
+
+// closingQuote returns the index of the quote which closes the double-quoted string at the
+// start of value, or -1.
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+
+// stripComment removes any '#' comment which isn't inside quotes from the end of a value.
+func stripComment(value string) string {
+	quote := byte(0)
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return value[:i]
+		}
+	}
+	return value
+}
+
+
+// splitList splits the items of a list on the commas which aren't inside quotes.
+func splitList(list string) []string {
+	var items []string
+	start, quote := 0, byte(0)
+	for i := 0; i < len(list); i++ {
+		switch c := list[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, list[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, list[start:])
+}
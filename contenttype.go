@@ -0,0 +1,93 @@
+package main
+
+// Walk-time skipping of files by their detected content type, for --skip-type.
+//
+// The type is sniffed from the first sniffBytes of the file by http.DetectContentType, so it
+// doesn't depend on the file being named correctly. Patterns are globs matched against the
+// type without any parameters, such as "image/*" or "application/zip".
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+
+// sniffBytes is how much of a file http.DetectContentType considers.
+const sniffBytes = 512
+
+
+// skippedTypes counts the files skipped by --skip-type, by the type they were detected as.
+// It is only touched by the walk.
+var skippedTypes = make(map[string]int64)
+
+
+// contentType returns the detected type of a file without parameters, or an empty string if
+// it couldn't be read.
+func contentType(pathname string) string {
+	file, err := openFile(pathname)
+	if err != nil {
+		return ""
+	}
+
+	defer file.Close()
+
+	buffer := make([]byte, sniffBytes)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return ""
+	}
+
+	detected := http.DetectContentType(buffer[:n])
+	if semi := strings.IndexByte(detected, ';'); semi >= 0 {
+		detected = detected[:semi]
+	}
+	return strings.TrimSpace(detected)
+}
+
+
+// isSkippedType reports whether a file's content type matches any of the --skip-type patterns,
+// counting it if so. Files which can't be read aren't skipped so that hashing reports them.
+func isSkippedType(pathname string) bool {
+	if len(*SkipTypes) == 0 {
+		return false
+	}
+
+	detected := contentType(pathname)
+	if detected == "" {
+		return false
+	}
+
+	for _, pattern := range *SkipTypes {
+		if matched, _ := path.Match(pattern, detected); matched {
+			skippedTypes[detected]++
+			return true
+		}
+	}
+
+	return false
+}
+
+
+// reportSkippedTypes logs how many files of each type --skip-type skipped.
+func reportSkippedTypes() {
+	if len(skippedTypes) == 0 {
+		return
+	}
+
+	types := make([]string, 0, len(skippedTypes))
+	for detected := range skippedTypes {
+		types = append(types, detected)
+	}
+	sort.Strings(types)
+
+	counts := make([]string, len(types))
+	for i, detected := range types {
+		counts[i] = fmt.Sprintf("%s:%d", detected, skippedTypes[detected])
+	}
+	log.Print("Skipped by type: ", strings.Join(counts, ", "))
+}
@@ -0,0 +1,22 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "os"
+
+
+// hasDevices reports whether fileDevice can tell devices apart here.
+const hasDevices = false
+
+
+// fileDevice returns 0 as there are no device ids here.
+func fileDevice(info os.FileInfo) uint64 {
+	return 0
+}
+
+
+// fileID can't identify files here, as FileInfo carries no file index.
+func fileID(info os.FileInfo) ([2]uint64, bool) {
+	return [2]uint64{}, false
+}
@@ -0,0 +1,34 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+
+// hasDevices reports whether fileDevice can tell devices apart here.
+const hasDevices = true
+
+
+// fileDevice returns the id of the device a file is on, or 0 if it isn't available.
+func fileDevice(info os.FileInfo) uint64 {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0
+	}
+	return uint64(stat.Dev)
+}
+
+
+// fileID returns the device and inode of a file, which together identify it however it is
+// reached, and whether they are available.
+func fileID(info os.FileInfo) ([2]uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return [2]uint64{}, false
+	}
+	return [2]uint64{uint64(stat.Dev), uint64(stat.Ino)}, true
+}
@@ -0,0 +1,171 @@
+package main
+
+// Duplicate directories, for --dirs.
+//
+// Every file's hash is collected on its way to the aggregation, and once everything has been
+// hashed each directory is given a hash of the sorted names and hashes of what it contains,
+// working up from the deepest. Directories with the same hash have the same files, with the
+// same names, all the way down.
+//
+// Only files that were hashed count, so files skipped for being too small, excluded or special
+// don't stop two directories matching. Directories with nothing hashed in them are ignored,
+// and so are duplicates whose parents are duplicates of each other too, since reporting the
+// parents says it all.
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+
+// dirEntry is a file or directory found in a directory, by its hash.
+type dirEntry struct {
+	name  string
+	hash  string
+	isDir bool
+}
+
+
+// dirNode is a directory seen while collecting hashes.
+type dirNode struct {
+	entries []dirEntry
+	bytes   int64
+	hash    string
+}
+
+
+// dirNodes are the directories containing hashed files, and their ancestors within the roots.
+var dirNodes = make(map[string]*dirNode)
+
+
+// dirNodeFor returns the node for a directory, creating it if need be.
+func dirNodeFor(dir string) *dirNode {
+	node, ok := dirNodes[dir]
+	if !ok {
+		node = &dirNode{}
+		dirNodes[dir] = node
+	}
+	return node
+}
+
+
+// dirTap records the hash of every reply passing through it against its directory, forwarding
+// them on to the returned channel.
+func dirTap(replies <-chan *FileHash) <-chan *FileHash {
+	tapped := make(chan *FileHash, cap(replies))
+
+	go func() {
+		defer close(tapped)
+		for reply := range replies {
+			node := dirNodeFor(filepath.Dir(reply.Pathname))
+			node.entries = append(node.entries, dirEntry{name: baseName(reply.Pathname), hash: reply.Hash})
+			node.bytes += reply.Size
+			tapped <- reply
+		}
+	}()
+
+	return tapped
+}
+
+
+// hashDirs gives every directory its hash, deepest first so that each directory's children
+// are done before it is.
+func hashDirs() {
+	// Make sure every directory between the files and their roots has a node.
+	for dir := range dirNodes {
+		for !isRoot(dir) && rootOf(dir) != "" {
+			dir = filepath.Dir(dir)
+			dirNodeFor(dir)
+		}
+	}
+
+	dirs := make([]string, 0, len(dirNodes))
+	for dir := range dirNodes {
+		dirs = append(dirs, dir)
+	}
+	depth := func(dir string) int { return strings.Count(filepath.ToSlash(dir), "/") }
+	sort.Slice(dirs, func(i, j int) bool { return depth(dirs[i]) > depth(dirs[j]) })
+
+	for _, dir := range dirs {
+		node := dirNodes[dir]
+		sort.Slice(node.entries, func(i, j int) bool { return node.entries[i].name < node.entries[j].name })
+
+		hasher := sha512.New()
+		for _, entry := range node.entries {
+			kind := "f"
+			if entry.isDir {
+				kind = "d"
+			}
+			fmt.Fprintf(hasher, "%s %q %s\n", kind, entry.name, entry.hash)
+		}
+		node.hash = fmt.Sprintf("%016d.dir.%s", node.bytes, hex.EncodeToString(hasher.Sum(nil)))
+
+		if isRoot(dir) || rootOf(dir) == "" {
+			continue
+		}
+		parent := dirNodes[filepath.Dir(dir)]
+		parent.entries = append(parent.entries, dirEntry{name: baseName(dir), hash: node.hash, isDir: true})
+		parent.bytes += node.bytes
+	}
+}
+
+
+// duplicateDirs returns the groups of identical directories, largest first.
+func duplicateDirs() []Group {
+	hashDirs()
+
+	buckets := make(map[string][]string)
+	for dir, node := range dirNodes {
+		buckets[node.hash] = append(buckets[node.hash], dir)
+	}
+
+	var groups []Group
+	for hash, dirs := range buckets {
+		if len(dirs) < 2 || parentsMatch(dirs, buckets) {
+			continue
+		}
+		group := Group{Hash: hash, Size: hashSize(hash), Files: keeperFirst(sortFiles(dirs))}
+		annotate(&group)
+		if isReportable(group) {
+			groups = append(groups, group)
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Size != groups[j].Size {
+			return groups[i].Size > groups[j].Size
+		}
+		return groups[i].Hash < groups[j].Hash
+	})
+
+	var wasted int64
+	for _, group := range groups {
+		wasted += group.WastedBytes()
+	}
+	log.Print("Duplicate directories: ", len(groups), " groups, ", formatBytes(wasted), " in redundant copies.")
+
+	return groups
+}
+
+
+// parentsMatch reports whether a group of directories all have parents which are themselves
+// a group of duplicates.
+func parentsMatch(dirs []string, buckets map[string][]string) bool {
+	hash := ""
+	for _, dir := range dirs {
+		if isRoot(dir) {
+			return false
+		}
+		parent, ok := dirNodes[filepath.Dir(dir)]
+		if !ok || (hash != "" && parent.hash != hash) {
+			return false
+		}
+		hash = parent.hash
+	}
+	return len(buckets[hash]) > 1
+}
@@ -0,0 +1,64 @@
+package main
+
+// Space taken up on disk, for --disk-usage.
+//
+// The space a group wastes is normally its size times the number of extra copies, but what
+// deleting them would actually free depends on how they are stored: sparse files and
+// compressing filesystems take up less than their size, filesystems with large blocks more,
+// and copies which are hard links to each other take up the space once between them. Each
+// group is noted with the space its files have allocated and what removing all but the
+// keeper would free, counting each inode once, and the totals are logged after the scan.
+
+import (
+	"fmt"
+	"log"
+)
+
+
+// groupDiskUsage returns the space allocated to a group's files, and how much of it removing
+// all but the first would free.
+func groupDiskUsage(group Group) (allocated, reclaimable int64) {
+	seen := make(map[[2]uint64]bool, len(group.Files))
+	for i, file := range group.Files {
+		info, err := fileSystem.Stat(file)
+		if err != nil {
+			continue
+		}
+		// A hard link to a file already counted takes up no more space, and frees none.
+		if id, ok := fileID(info); ok {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+		}
+		bytes := allocatedBytes(info)
+		allocated += bytes
+		if i > 0 {
+			reclaimable += bytes
+		}
+	}
+	return allocated, reclaimable
+}
+
+
+// diskUsageNote describes a group's use of disk space.
+func diskUsageNote(group Group) string {
+	allocated, reclaimable := groupDiskUsage(group)
+	return fmt.Sprintf("disk usage: %s allocated, %s reclaimable on disk (%s by size)",
+		formatBytes(allocated), formatBytes(reclaimable), formatBytes(group.WastedBytes()))
+}
+
+
+// logDiskUsage logs what the reportable groups waste by size against what removing their
+// duplicates would free on disk.
+func logDiskUsage(collisions CollisionTable) {
+	var wasted, allocated, reclaimable int64
+	for _, group := range sortedGroups(collisions) {
+		groupAllocated, groupReclaimable := groupDiskUsage(group)
+		wasted += group.WastedBytes()
+		allocated += groupAllocated
+		reclaimable += groupReclaimable
+	}
+	log.Print("Disk usage: duplicates waste ", formatBytes(wasted), " by size, ", formatBytes(reclaimable),
+		" on disk; ", formatBytes(allocated), " allocated to the groups in all")
+}
@@ -0,0 +1,108 @@
+package main
+
+// Reporting and pruning of empty directories.
+//
+// This considers every directory under the roots, not just ones emptied by this run: a
+// directory is empty if it contains nothing but (recursively) empty directories. The roots
+// themselves are never reported or removed, and nor is anything the walk skips: excluded and
+// hidden directories, and findupe's own files, count as content of the directory holding them.
+// A root inside another is left to its own walk, so its empty directories are listed once.
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+
+// findEmptyDirs returns every directory under root which contains nothing but empty
+// directories, deepest first, so that they can be removed in order.
+func findEmptyDirs(root string) []string {
+	// How many entries each directory had that were not themselves directories.
+	contents := make(map[string]int)
+	var dirs []string
+
+	walkingRoot = root
+	fileSystem.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info == nil {
+			// If we can't see inside it, we can't call it empty.
+			contents[path]++
+			contents[filepath.Dir(path)]++
+			return nil
+		}
+		// What the walk leaves alone is left alone here too, and keeps its parent from being
+		// empty. So are the roots inside this one, which are looked through on their own.
+		if (isExcluded(path) && !isRoot(path)) || isOwnFile(path, info) || (info.IsDir() && (isHiddenDir(path) || isOtherRoot(path))) {
+			contents[filepath.Dir(path)]++
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+			if _, seen := contents[path]; !seen {
+				contents[path] = 0
+			}
+			return nil
+		}
+		contents[filepath.Dir(path)]++
+		return nil
+	})
+
+	// Process the deepest directories first so that a non-empty directory marks each of its
+	// ancestors as non-empty.
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], string(filepath.Separator)) > strings.Count(dirs[j], string(filepath.Separator))
+	})
+
+	var empty []string
+	for _, dir := range dirs {
+		if isRoot(dir) {
+			continue
+		}
+		if contents[dir] == 0 {
+			empty = append(empty, dir)
+			continue
+		}
+		contents[filepath.Dir(dir)]++
+	}
+
+	return empty
+}
+
+
+// reportEmptyDirs lists, and with --prune-empty-dirs removes, the empty directories under
+// the roots.
+func reportEmptyDirs() {
+	var empty []string
+	for _, root := range roots {
+		empty = append(empty, findEmptyDirs(root)...)
+	}
+	removed := 0
+	for _, dir := range empty {
+		if !*PruneEmptyDirs {
+			fmt.Printf("empty: %q\n", dir)
+			continue
+		}
+		if *DryRun {
+			fmt.Printf("would remove: %q\n", dir)
+			continue
+		}
+		if err := os.Remove(dir); err != nil {
+			log.Printf("error removing %s: %s", dir, err.Error())
+			continue
+		}
+		fmt.Printf("removed: %q\n", dir)
+		removed++
+	}
+
+	if *PruneEmptyDirs && *DryRun {
+		log.Print("Dry run: would remove ", len(empty), " empty directories")
+		return
+	}
+	log.Print("Empty directories:", len(empty), ", Removed:", removed)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+
+func TestNestedRootEmptyDirs(t *testing.T) {
+	dir := t.TempDir()
+	for _, empty := range []string{"outer/gone", "outer/inner/gone", "outer/inner/deeper/gone"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.FromSlash(empty)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outer, inner := filepath.Join(dir, "outer"), filepath.Join(dir, "outer", "inner")
+	roots = []string{outer, inner}
+	defer func() { roots = nil }()
+
+	var found []string
+	for _, root := range roots {
+		found = append(found, findEmptyDirs(root)...)
+	}
+
+	// The inner root's directories are found under it alone, and the root itself keeps the
+	// outer one's from being empty.
+	want := []string{
+		filepath.Join(outer, "gone"),
+		filepath.Join(inner, "deeper", "gone"), filepath.Join(inner, "deeper"), filepath.Join(inner, "gone"),
+	}
+	sort.Strings(found)
+	sort.Strings(want)
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("found %q, want %q", found, want)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+// Reporting of files that couldn't be read.
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+
+// FileError records a file that couldn't be processed and why.
+type FileError struct {
+	Pathname string
+	Message  string
+}
+
+
+// fileErrors collects every FileError for --errors-output; it is appended to by the workers,
+// so hold fileErrorsLock.
+var fileErrors []FileError
+var fileErrorsLock sync.Mutex
+
+
+// recordError notes a file that couldn't be processed for --errors-output, without logging it.
+func recordError(pathname string, err error) {
+	if *ErrorsOutput == "" {
+		return
+	}
+	fileErrorsLock.Lock()
+	defer fileErrorsLock.Unlock()
+	fileErrors = append(fileErrors, FileError{Pathname: pathname, Message: err.Error()})
+}
+
+
+// errorLogBurst is how many errors are logged before they are rate limited to one every
+// errorLogInterval, so that a broken mount doesn't bury everything else in the log.
+const (
+	errorLogBurst    = 20
+	errorLogInterval = 5 * time.Second
+)
+
+// loggedErrors and suppressedErrors count the errors logged and left out by fileError, and
+// lastErrorLog is when one was last logged after the burst; hold errorLogLock.
+var loggedErrors, suppressedErrors int64
+var lastErrorLog time.Time
+var errorLogLock sync.Mutex
+
+
+// shouldLogError reports whether the next error can be logged, and with how many were left
+// out since the last one.
+func shouldLogError() (bool, int64) {
+	errorLogLock.Lock()
+	defer errorLogLock.Unlock()
+
+	if loggedErrors >= errorLogBurst && time.Since(lastErrorLog) < errorLogInterval {
+		suppressedErrors++
+		return false, 0
+	}
+	loggedErrors++
+	lastErrorLog = time.Now()
+	skipped := suppressedErrors
+	suppressedErrors = 0
+	return true, skipped
+}
+
+
+// fileError logs, unless --quiet or too many have been logged lately, and records a file that
+// couldn't be processed.
+func fileError(action, pathname string, err error) {
+	if !*Quiet {
+		if ok, skipped := shouldLogError(); ok && skipped > 0 {
+			log.Printf("error %s %s: %s (%d more errors not shown)", action, pathname, err.Error(), skipped)
+		} else if ok {
+			log.Printf("error %s %s: %s", action, pathname, err.Error())
+		}
+	}
+	recordError(pathname, fmt.Errorf("%s: %w", action, err))
+}
+
+
+// reportSuppressedErrors logs how many errors were left out since the last one was logged.
+func reportSuppressedErrors() {
+	errorLogLock.Lock()
+	defer errorLogLock.Unlock()
+
+	if suppressedErrors == 0 {
+		return
+	}
+	if *ErrorsOutput != "" {
+		log.Print("... and ", suppressedErrors, " more errors, listed in ", *ErrorsOutput)
+	} else {
+		log.Print("... and ", suppressedErrors, " more errors; use --errors-output to list them all")
+	}
+}
+
+
+// lockedFiles counts files which couldn't be read because they were in use; it is updated by
+// the workers, so use atomic operations.
+var lockedFiles int64
+
+
+// readError reports a file that couldn't be read, unless it was locked and --skip-locked says
+// to quietly skip such files.
+func readError(pathname string, err error) {
+	if isLocked(err) {
+		atomic.AddInt64(&lockedFiles, 1)
+		if *SkipLocked {
+			return
+		}
+	}
+	fileError("reading", pathname, err)
+}
+
+
+// writeErrors writes the collected errors to the --errors-output file, one per line as the
+// path and the error separated by a tab.
+func writeErrors(filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, fileErr := range fileErrors {
+		fmt.Fprintf(writer, "%s\t%s\n", fileErr.Pathname, fileErr.Message)
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+
+	log.Print("Errors:", len(fileErrors), " written to ", filename)
+
+	return file.Close()
+}
@@ -0,0 +1,86 @@
+package main
+
+// Walk-time exclusion of files and directories by glob pattern.
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+
+// excludePatterns is every pattern from --exclude and --exclude-from, and those implied by
+// --skip-apple-metadata.
+var excludePatterns []string
+
+
+// appleMetadataPatterns match what macOS leaves behind on filesystems without resource forks
+// or extended attributes: the AppleDouble "._" file alongside each file, the .AppleDouble
+// directories some file servers keep them in, and the Finder's .DS_Store.
+var appleMetadataPatterns = []string{"._*", ".AppleDouble", ".DS_Store"}
+
+
+// loadExcludes gathers the --exclude patterns and those read from the --exclude-from file,
+// which has one pattern per line with blank lines and '#' comments ignored.
+func loadExcludes() error {
+	excludePatterns = append(excludePatterns, *Excludes...)
+	if *SkipAppleMetadata {
+		excludePatterns = append(excludePatterns, appleMetadataPatterns...)
+	}
+	if *ExcludeFrom == "" {
+		return nil
+	}
+
+	file, err := os.Open(*ExcludeFrom)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		excludePatterns = append(excludePatterns, line)
+	}
+
+	return scanner.Err()
+}
+
+
+// isExcluded reports whether a path matches any of the exclude patterns, either by its base
+// name or by its path relative to its root.
+func isExcluded(path string) bool {
+	if len(excludePatterns) == 0 {
+		return false
+	}
+
+	name := filepath.Base(path)
+	relative := relativePath(path)
+
+	for _, pattern := range excludePatterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, relative); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+
+// isHiddenDir reports whether a directory should be skipped for --no-hidden-dir-descent: its
+// name starts with a dot, and it isn't a root.
+func isHiddenDir(dir string) bool {
+	if !*NoHiddenDirDescent || isRoot(dir) {
+		return false
+	}
+	name := filepath.Base(dir)
+	return len(name) > 1 && name[0] == '.' && name != ".."
+}
@@ -0,0 +1,144 @@
+package main
+
+// The --fast pipeline.
+//
+// Rather than fully hashing every file, the fast pipeline runs in three passes, each of which
+// only forwards files that collided with at least one other file in the previous pass:
+//
+//   1. size: files are bucketed by their size, which costs no I/O at all,
+//   2. head: same-size files have their first HeadBytes hashed,
+//   3. full: files whose heads collided are fully hashed by the regular hashRequest.
+//
+// The final pass is exactly the same as the normal mode, so the results are exact.
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+
+// HeadBytes is how much of the start of each file the head pass reads.
+const HeadBytes = 4096
+
+
+// hashHead is like hashData but only reads up to the first n bytes of the file (or of the
+// --offset/--length range).
+func hashHead(pathname string, n int64) (string, error) {
+	file, reader, err := openContent(pathname)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	hasher := sha512.New()
+	if _, err = io.CopyN(hasher, reader, n); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+
+// sizeRequest keys a request on nothing but its size.
+func sizeRequest(request *FileHash) *FileHash {
+	request.Hash = fmt.Sprintf("%016d", request.Size)
+	return request
+}
+
+
+// headRequest keys a request on its size and the hash of its first HeadBytes.
+func headRequest(request *FileHash) *FileHash {
+	// Link targets are tiny, leave them for the full pass.
+	if request.IsLink {
+		request.Hash = fmt.Sprintf("%016d.link", request.Size)
+		return request
+	}
+
+	pathname := strings.ReplaceAll(request.Pathname, "\\", "/")
+	hashString, err := hashHead(pathname, HeadBytes)
+	if err != nil {
+		readError(pathname, err)
+		return nil
+	}
+
+	request.Hash = fmt.Sprintf("%016d.head.%s", request.Size, hashString)
+
+	return request
+}
+
+
+// sizeStage applies sizeRequest to everything from requests in the calling goroutine; there's
+// no I/O involved, so there's no point in farming it out to workers.
+func sizeStage(requests <-chan *FileHash, replies chan<- *FileHash) {
+	defer close(replies)
+
+	for request := range requests {
+		replies <- sizeRequest(request)
+	}
+}
+
+
+// collisionStage is a barrier which buckets everything from replies by Hash and, once the
+// channel is drained, forwards only the members of buckets with two or more files, discarding
+// the rest. Each bucket is forwarded as a Batch, as its files can't collide with any others.
+// The label is used to report how many files survived.
+func collisionStage(label string, replies <-chan *FileHash, candidates chan<- *FileHash) {
+	defer close(candidates)
+
+	buckets := make(map[string][]*FileHash)
+	var seen int
+	for reply := range replies {
+		seen++
+		buckets[reply.Hash] = append(buckets[reply.Hash], reply)
+	}
+
+	// Forward the buckets in a repeatable order if asked to; otherwise it doesn't matter.
+	keys := make([]string, 0, len(buckets))
+	for key := range buckets {
+		keys = append(keys, key)
+	}
+	if *Deterministic {
+		sort.Strings(keys)
+	}
+
+	var kept int
+	for _, key := range keys {
+		bucket := buckets[key]
+		if len(bucket) < 2 {
+			continue
+		}
+		batch := &Batch{pending: int64(len(bucket))}
+		for _, candidate := range bucket {
+			candidate.Batch = batch
+			kept++
+			candidates <- candidate
+		}
+	}
+
+	log.Print(label, " pass: ", seen, " files in, ", kept, " candidates out.")
+}
+
+
+// fastPipeline wires up the three passes of the fast mode in the background, with the full
+// hashes being delivered to replies.
+func fastPipeline(requests <-chan *FileHash, replies chan<- *FileHash) {
+	sized, sizeCandidates := make(chan *FileHash, *Threads*2), make(chan *FileHash, *Threads*2)
+	heads, headCandidates := make(chan *FileHash, *Threads*2), make(chan *FileHash, *Threads*2)
+
+	// Pass 1: bucket by size.
+	go sizeStage(requests, sized)
+	go collisionStage("Size", sized, sizeCandidates)
+
+	// Pass 2: bucket by the head of the file.
+	go workers(sizeCandidates, heads, headRequest)
+	go collisionStage("Head", heads, headCandidates)
+
+	// Pass 3: full hash of whatever is left.
+	go workers(headCandidates, replies, hashRequest)
+}
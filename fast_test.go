@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+
+// fastTree is a tree for the --fast passes: every file but one is the same size, two differ in
+// their heads, two more only in their tails, and two are identical.
+func fastTree(t *testing.T) *countingFileSystem {
+	filler := strings.Repeat("x", 2*HeadBytes)
+	fsys := countFiles(NewIOFileSystem(fstest.MapFS{
+		"a": {Data: []byte("A" + filler)},
+		"b": {Data: []byte("B" + filler)},
+		"c": {Data: []byte(filler + "C")},
+		"d": {Data: []byte(filler + "D")},
+		"e": {Data: []byte("a size of its own")},
+		"f": {Data: []byte(filler + "F")},
+		"g": {Data: []byte(filler + "F")},
+	}))
+	useFileSystem(t, fsys)
+	return fsys
+}
+
+
+// fastRequests sends a request for every file in fsys, closing the channel after the last.
+func fastRequests(t *testing.T, fsys FileSystem, names ...string) <-chan *FileHash {
+	requests := make(chan *FileHash, len(names))
+	for _, name := range names {
+		info, err := fsys.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		requests <- &FileHash{Pathname: name, Size: info.Size(), ModTime: info.ModTime()}
+	}
+	close(requests)
+	return requests
+}
+
+
+// drain collects the names of everything from a channel, sorted.
+func drain(candidates <-chan *FileHash) []string {
+	var names []string
+	for candidate := range candidates {
+		names = append(names, candidate.Pathname)
+	}
+	sort.Strings(names)
+	return names
+}
+
+
+func TestCollisionStage(t *testing.T) {
+	replies := make(chan *FileHash, 5)
+	for _, reply := range []*FileHash{
+		{Pathname: "a", Hash: "1"}, {Pathname: "b", Hash: "2"}, {Pathname: "c", Hash: "1"},
+		{Pathname: "d", Hash: "3"}, {Pathname: "e", Hash: "1"},
+	} {
+		replies <- reply
+	}
+	close(replies)
+
+	candidates := make(chan *FileHash, 5)
+	collisionStage("Test", replies, candidates)
+
+	var names []string
+	for candidate := range candidates {
+		names = append(names, candidate.Pathname)
+		if candidate.Batch == nil || candidate.Batch.pending != 3 {
+			t.Errorf("%s wasn't forwarded as one of a batch of 3", candidate.Pathname)
+		}
+	}
+	sort.Strings(names)
+	if want := []string{"a", "c", "e"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("forwarded %q, want %q", names, want)
+	}
+}
+
+
+func TestSizePass(t *testing.T) {
+	fsys := fastTree(t)
+
+	sized, candidates := make(chan *FileHash, 8), make(chan *FileHash, 8)
+	go sizeStage(fastRequests(t, fsys, "a", "b", "c", "d", "e", "f", "g"), sized)
+	collisionStage("Size", sized, candidates)
+
+	if got, want := drain(candidates), []string{"a", "b", "c", "d", "f", "g"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("size pass forwarded %q, want %q", got, want)
+	}
+	if len(fsys.opened) != 0 {
+		t.Errorf("the size pass opened %v", fsys.opened)
+	}
+}
+
+
+func TestHeadPass(t *testing.T) {
+	fsys := fastTree(t)
+
+	// Same-size files whose heads differ go no further.
+	heads, candidates := make(chan *FileHash, 8), make(chan *FileHash, 8)
+	go workers(fastRequests(t, fsys, "a", "b", "c", "d", "f", "g"), heads, headRequest)
+	collisionStage("Head", heads, candidates)
+
+	if got, want := drain(candidates), []string{"c", "d", "f", "g"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("head pass forwarded %q, want %q", got, want)
+	}
+	if fsys.read > 6*HeadBytes {
+		t.Errorf("the head pass read %d bytes, want at most %d", fsys.read, 6*HeadBytes)
+	}
+}
+
+
+func TestFastPipeline(t *testing.T) {
+	fsys := fastTree(t)
+
+	replies := make(chan *FileHash, 8)
+	fastPipeline(fastRequests(t, fsys, "a", "b", "c", "d", "e", "f", "g"), replies)
+
+	// Files with the same head are still told apart by the full hash.
+	checkGroups(t, "", aggregateHashes(replies, nil), "f g")
+
+	// A file of a size of its own is never read, and files whose heads differ are read once.
+	want := map[string]int{"a": 1, "b": 1, "c": 2, "d": 2, "f": 2, "g": 2}
+	if !reflect.DeepEqual(fsys.opened, want) {
+		t.Errorf("opened %v, want %v", fsys.opened, want)
+	}
+}
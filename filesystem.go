@@ -0,0 +1,135 @@
+package main
+
+// Filesystem abstraction.
+//
+// Walking and hashing go through fileSystem rather than calling the os package directly, so
+// that an in-memory filesystem (such as testing/fstest.MapFS) can be substituted for the disk.
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+
+// File is an open file which can be read and positioned.
+type File interface {
+	io.ReadSeekCloser
+}
+
+
+// FileSystem is the set of filesystem operations findupe performs.
+type FileSystem interface {
+	// Open opens the named file for reading.
+	Open(name string) (File, error)
+	// Stat returns information about the named file, following symlinks.
+	Stat(name string) (os.FileInfo, error)
+	// Lstat returns information about the named file without following symlinks.
+	Lstat(name string) (os.FileInfo, error)
+	// Readlink returns the target of the named symlink.
+	Readlink(name string) (string, error)
+	// ReadDirNames returns the names of the entries in the named directory, sorted.
+	ReadDirNames(name string) ([]string, error)
+	// Walk calls fn for every file and directory under root, as per filepath.Walk.
+	Walk(root string, fn filepath.WalkFunc) error
+}
+
+
+// fileSystem is the filesystem in use, which is the real one unless replaced.
+var fileSystem FileSystem = osFileSystem{}
+
+
+// osFileSystem is a FileSystem backed by the operating system.
+type osFileSystem struct{}
+
+func (osFileSystem) Open(name string) (File, error)               { return os.Open(name) }
+func (osFileSystem) Stat(name string) (os.FileInfo, error)        { return os.Stat(name) }
+func (osFileSystem) Lstat(name string) (os.FileInfo, error)       { return os.Lstat(name) }
+func (osFileSystem) Readlink(name string) (string, error)         { return os.Readlink(name) }
+func (osFileSystem) Walk(root string, fn filepath.WalkFunc) error { return filepath.Walk(root, fn) }
+
+func (osFileSystem) ReadDirNames(name string) ([]string, error) {
+	entries, err := os.ReadDir(name)
+	return entryNames(entries), err
+}
+
+
+// entryNames returns the names of directory entries, which ReadDir has already sorted.
+func entryNames(entries []fs.DirEntry) []string {
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names
+}
+
+
+// ioFileSystem adapts an io/fs.FS into a FileSystem. Names are slash-separated and relative
+// to the root of the FS; there are no symlinks, as fs.FS has no way of describing them.
+type ioFileSystem struct {
+	fsys fs.FS
+}
+
+
+// errNotSeekable is returned when a file from an fs.FS can't be positioned.
+var errNotSeekable = errors.New("file does not support seeking")
+
+
+// seekableFile gives fs.File implementations which don't support Seek an implementation which
+// fails, so that they still satisfy File.
+type seekableFile struct {
+	fs.File
+}
+
+func (f seekableFile) Seek(offset int64, whence int) (int64, error) {
+	if seeker, ok := f.File.(io.Seeker); ok {
+		return seeker.Seek(offset, whence)
+	}
+	return 0, errNotSeekable
+}
+
+
+// NewIOFileSystem returns a FileSystem which reads from fsys.
+func NewIOFileSystem(fsys fs.FS) FileSystem {
+	return ioFileSystem{fsys: fsys}
+}
+
+func (f ioFileSystem) Open(name string) (File, error) {
+	file, err := f.fsys.Open(filepath.ToSlash(name))
+	if err != nil {
+		return nil, err
+	}
+	if seekable, ok := file.(File); ok {
+		return seekable, nil
+	}
+	return seekableFile{file}, nil
+}
+
+func (f ioFileSystem) Stat(name string) (os.FileInfo, error) {
+	return fs.Stat(f.fsys, filepath.ToSlash(name))
+}
+
+func (f ioFileSystem) Lstat(name string) (os.FileInfo, error) {
+	return f.Stat(name)
+}
+
+func (f ioFileSystem) Readlink(name string) (string, error) {
+	return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+}
+
+func (f ioFileSystem) ReadDirNames(name string) ([]string, error) {
+	entries, err := fs.ReadDir(f.fsys, filepath.ToSlash(name))
+	return entryNames(entries), err
+}
+
+func (f ioFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	return fs.WalkDir(f.fsys, filepath.ToSlash(root), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return fn(path, nil, err)
+		}
+		info, err := entry.Info()
+		return fn(path, info, err)
+	})
+}
@@ -1,5 +1,10 @@
 module github.com/kfsone/findupe
 
-go 1.15
+go 1.16
 
-require github.com/spf13/pflag v1.0.5
+require (
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	golang.org/x/text v0.3.7
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,68 @@
+package main
+
+// The file size histogram, for --histogram.
+//
+// Every hashed file is counted in a bucket by size, the buckets going up in powers of ten
+// from under 1KiB, and the counts are logged once everything has been hashed.
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+
+// histogramLabels name the buckets, each ten times the size of the one before.
+var histogramLabels = []string{"<1K", "1-10K", "10-100K", "100K-1M", "1-10M", "10-100M", "100M-1G", "1-10G", ">=10G"}
+
+// histogram counts files by bucket; only the tap updates it.
+var histogram = make([]int64, len(histogramLabels))
+
+
+// histogramBucket returns the bucket for a file size.
+func histogramBucket(size int64) int {
+	bucket, limit := 0, int64(1024)
+	for size >= limit && bucket < len(histogramLabels)-1 {
+		bucket++
+		limit *= 10
+	}
+	return bucket
+}
+
+
+// histogramTap counts the size of every reply passing through it, forwarding them on to the
+// returned channel.
+func histogramTap(replies <-chan *FileHash) <-chan *FileHash {
+	tapped := make(chan *FileHash, cap(replies))
+
+	go func() {
+		defer close(tapped)
+		for reply := range replies {
+			histogram[histogramBucket(reply.Size)]++
+			tapped <- reply
+		}
+	}()
+
+	return tapped
+}
+
+
+// logHistogram logs the counts in each bucket, with a bar scaled to the largest.
+func logHistogram() {
+	var largest int64
+	for _, count := range histogram {
+		if count > largest {
+			largest = count
+		}
+	}
+
+	log.Print("File sizes:")
+	for bucket, count := range histogram {
+		width := 0
+		if largest > 0 {
+			width = int(count * 40 / largest)
+		}
+		line := fmt.Sprintf("  %8s %12s %s", histogramLabels[bucket], formatCount(count), strings.Repeat("#", width))
+		log.Print(strings.TrimRight(line, " "))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+// Stopping early on Ctrl-C (or SIGTERM) without losing what has been done.
+//
+// The first interrupt stops the walk and has the workers drop whatever is still queued, so
+// the pipeline drains as if the tree had ended there: the files being read are finished, the
+// live manifest and --dump-hashes output are flushed and closed, and the cache and the error
+// list are saved with everything hashed so far. The partial results aren't reported or acted
+// on, since files that weren't reached could belong to any group. A second interrupt quits at
+// once.
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+
+// interrupted is non-zero once the scan has been interrupted.
+var interrupted int32
+
+
+// interruptCh is closed once the scan has been interrupted, for anything waiting on it.
+var interruptCh = make(chan struct{})
+
+
+// errInterrupted stops a walk which has been interrupted.
+var errInterrupted = errors.New("interrupted")
+
+
+// isInterrupted reports whether the scan has been interrupted.
+func isInterrupted() bool {
+	return atomic.LoadInt32(&interrupted) != 0
+}
+
+
+// watchInterrupts winds the scan down on the first interrupt, leaving the second to kill the
+// process as usual.
+func watchInterrupts() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+		interrupt()
+	}()
+}
+
+
+// interrupt winds the scan down.
+func interrupt() {
+	atomic.StoreInt32(&interrupted, 1)
+	close(interruptCh)
+	log.Print("Interrupted: finishing the files being read and saving what was hashed; interrupt again to quit at once")
+	// A paused scan would never get to wind down.
+	setPaused(false)
+}
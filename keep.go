@@ -0,0 +1,173 @@
+package main
+
+// Selection of the file to keep from each group of duplicates.
+//
+// The keeper is listed first in each group. If any --prefer-prefix matches, only the files
+// under the earliest-listed matching prefix are considered; the --keep strategy then picks
+// between whichever files are still in the running.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+
+// keepStrategies are the valid values for --keep.
+var keepStrategies = []string{"first", "last", "shortest", "longest", "oldest", "newest"}
+
+
+// hasPathPrefix reports whether path, or path relative to its root, is prefix or is under it.
+// Whole names are compared, so "primary" doesn't match "primary2/a".
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(filepath.ToSlash(prefix), "/")
+	return isUnderPrefix(filepath.ToSlash(path), prefix) || isUnderPrefix(relativePath(path), prefix)
+}
+
+
+// isUnderPrefix reports whether a slash-separated path is prefix or is under it.
+func isUnderPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+
+// modTime returns the modification time of a file, or the zero time if it can't be stat'd.
+func modTime(path string) time.Time {
+	info, err := fileSystem.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+
+// isFollowedLink reports whether a file is a symlink whose target was hashed, with
+// --symlink-mode follow or --follow-top-symlinks.
+func isFollowedLink(path string) bool {
+	if *SymlinkMode != "follow" && !*FollowTopSymlinks {
+		return false
+	}
+	info, err := fileSystem.Lstat(path)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+
+// isBetterKeeper reports whether candidate is preferable to best under the --keep strategy.
+func isBetterKeeper(candidate, best string) bool {
+	switch *Keep {
+	case "last":
+		return candidate > best
+	case "shortest":
+		return len(candidate) < len(best) || (len(candidate) == len(best) && candidate < best)
+	case "longest":
+		return len(candidate) > len(best) || (len(candidate) == len(best) && candidate < best)
+	case "oldest", "newest":
+		candidateTime, bestTime := modTime(candidate), modTime(best)
+		switch {
+		case candidateTime.IsZero() != bestTime.IsZero():
+			// Anything we can stat beats something we can't.
+			return bestTime.IsZero()
+		case candidateTime.Equal(bestTime):
+			return candidate < best
+		case *Keep == "oldest":
+			return candidateTime.Before(bestTime)
+		default:
+			return candidateTime.After(bestTime)
+		}
+	default:
+		return candidate < best
+	}
+}
+
+
+// chooseKeeper returns the index of the file that should be kept from a group.
+func chooseKeeper(files []string) int {
+	// Narrow the candidates down to the earliest prefix that any of them match.
+	candidates := make([]int, 0, len(files))
+	for _, prefix := range *PreferPrefixes {
+		for i, file := range files {
+			if hasPathPrefix(file, prefix) {
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) > 0 {
+			break
+		}
+	}
+	if len(candidates) == 0 {
+		for i := range files {
+			candidates = append(candidates, i)
+		}
+	}
+
+	// Neither a member of an archive nor a followed symlink can be kept in place of the rest,
+	// so prefer the files themselves.
+	var onDisk []int
+	for _, i := range candidates {
+		if !isMember(files[i]) && !isFollowedLink(files[i]) {
+			onDisk = append(onDisk, i)
+		}
+	}
+	if len(onDisk) > 0 {
+		candidates = onDisk
+	}
+
+	best := candidates[0]
+	for _, i := range candidates[1:] {
+		if isBetterKeeper(files[i], files[best]) {
+			best = i
+		}
+	}
+
+	return best
+}
+
+
+// keeperFirst moves the file that should be kept to the front of a group, leaving the order of
+// the rest alone.
+func keeperFirst(files []string) []string {
+	if len(files) < 2 {
+		return files
+	}
+	if keeper := chooseKeeper(files); keeper != 0 {
+		kept := files[keeper]
+		copy(files[1:keeper+1], files[:keeper])
+		files[0] = kept
+	}
+	return files
+}
+
+
+// keepReasons describes the --keep strategies for keepSuggestion.
+var keepReasons = map[string]string{
+	"first":    "first by path",
+	"last":     "last by path",
+	"shortest": "shortest path",
+	"longest":  "longest path",
+	"oldest":   "oldest",
+	"newest":   "newest",
+}
+
+
+// keepSuggestion describes which file of a group, already in keeperFirst order, should be kept
+// and why, for --suggest.
+func keepSuggestion(files []string) string {
+	keeper := files[0]
+	var reasons []string
+	for _, prefix := range *PreferPrefixes {
+		if hasPathPrefix(keeper, prefix) {
+			reasons = append(reasons, "under "+prefix)
+			break
+		}
+	}
+	reason := keepReasons[*Keep]
+	if *Keep == "oldest" || *Keep == "newest" {
+		if mtime := modTime(keeper); !mtime.IsZero() {
+			reason += ", modified " + mtime.Format("2006-01-02 15:04:05")
+		}
+	}
+	reasons = append(reasons, reason)
+	return fmt.Sprintf("keep %q: %s", keeper, strings.Join(reasons, ", "))
+}
\ No newline at end of file
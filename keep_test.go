@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+
+func TestHasPathPrefix(t *testing.T) {
+	for _, test := range []struct {
+		path, prefix string
+		want         bool
+	}{
+		{"primary/a.jpg", "primary", true},
+		{"primary/a.jpg", "primary/", true},
+		{"primary/sub/a.jpg", "primary", true},
+		{"primary/sub/a.jpg", "primary/sub", true},
+		{"primary", "primary", true},
+		{"primary2/a.jpg", "primary", false},
+		{"primary.old/a.jpg", "primary", false},
+		{"secondary/primary/a.jpg", "primary", false},
+		{"/mnt/primary/a.jpg", "/mnt/primary", true},
+		{"/mnt/primary/a.jpg", "/", true},
+	} {
+		if got := hasPathPrefix(test.path, test.prefix); got != test.want {
+			t.Errorf("hasPathPrefix(%q, %q) = %v, want %v", test.path, test.prefix, got, test.want)
+		}
+	}
+}
+
+
+func TestChooseKeeperPrefixes(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		prefixes []string
+		keep     string
+		files    []string
+		want     string
+	}{
+		{"only the preferred prefix", []string{"primary"}, "first",
+			[]string{"secondary/a.jpg", "primary/a.jpg"}, "primary/a.jpg"},
+		{"the earliest prefix listed", []string{"secondary", "primary"}, "first",
+			[]string{"primary/a.jpg", "secondary/a.jpg"}, "secondary/a.jpg"},
+		{"a later prefix when the first matches nothing", []string{"tertiary", "primary"}, "first",
+			[]string{"secondary/a.jpg", "primary/a.jpg"}, "primary/a.jpg"},
+		{"--keep among the files under the prefix", []string{"primary"}, "shortest",
+			[]string{"primary/deeper/a.jpg", "secondary/a.jpg", "primary/a.jpg"}, "primary/a.jpg"},
+		{"--keep among the files under the prefix, not the rest", []string{"primary"}, "longest",
+			[]string{"primary/a.jpg", "secondary/much/deeper/a.jpg", "primary/b/a.jpg"}, "primary/b/a.jpg"},
+		{"--keep when no prefix matches", []string{"tertiary"}, "last",
+			[]string{"primary/a.jpg", "secondary/a.jpg"}, "secondary/a.jpg"},
+		{"no partial names", []string{"primary"}, "first",
+			[]string{"primary2/a.jpg", "secondary/a.jpg", "primary/a.jpg"}, "primary/a.jpg"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			for _, prefix := range test.prefixes {
+				setFlag(t, "prefer-prefix", prefix)
+			}
+			setFlag(t, "keep", test.keep)
+			if got := test.files[chooseKeeper(test.files)]; got != test.want {
+				t.Errorf("kept %s, want %s", got, test.want)
+			}
+		})
+	}
+}
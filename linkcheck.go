@@ -0,0 +1,56 @@
+package main
+
+// The hard link preflight, for --link-check.
+//
+// A hard link can't cross devices, so --hardlink can only replace the duplicates which are on
+// the same device as the file being kept. The check stats every file of every group and
+// reports the groups which span devices before anything is touched.
+
+import (
+	"log"
+)
+
+
+// crossDeviceFiles returns the files of a group which aren't on the same device as the file
+// being kept.
+func crossDeviceFiles(group Group) []string {
+	keeper, err := fileSystem.Stat(group.Files[0])
+	if err != nil {
+		return nil
+	}
+
+	var crossing []string
+	for _, file := range group.Files[1:] {
+		if info, err := fileSystem.Stat(file); err == nil && fileDevice(info) != fileDevice(keeper) {
+			crossing = append(crossing, file)
+		}
+	}
+	return crossing
+}
+
+
+// logLinkCheck logs how many groups can't be fully hard linked because they span devices,
+// with the files of each which are on a different device from the file being kept.
+func logLinkCheck(collisions CollisionTable) {
+	if !hasDevices {
+		log.Print("Link check: device ids aren't available on this platform.")
+		return
+	}
+
+	var groups, files int
+	for _, group := range sortedGroups(collisions) {
+		crossing := crossDeviceFiles(group)
+		if len(crossing) == 0 {
+			continue
+		}
+		if groups == 0 {
+			log.Print("Not linkable (cross-device):")
+		}
+		groups++
+		files += len(crossing)
+		for _, file := range crossing {
+			log.Printf("  %q is on a different device from %q", file, group.Files[0])
+		}
+	}
+	log.Print("Link check: ", groups, " groups not linkable (cross-device), ", files, " files.")
+}
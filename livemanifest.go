@@ -0,0 +1,67 @@
+package main
+
+// The running record of hashes, for --live-manifest.
+//
+// Every reply is appended to the manifest as a {path, size, mtime, hash} record as soon as it
+// arrives, one per line, so that an interrupted scan leaves behind everything it had hashed.
+// The records are those of --dump-hashes --format json, so the manifest can be given to
+// --load-hashes to pick up where the scan left off.
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+
+// liveManifestFlush is how often the manifest is written out.
+const liveManifestFlush = time.Second
+
+
+// liveManifestTap writes every reply passing through it to the manifest, forwarding them on to
+// the returned channel.
+func liveManifestTap(replies <-chan *FileHash, filename string) (<-chan *FileHash, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	tapped := make(chan *FileHash, cap(replies))
+
+	go func() {
+		defer close(tapped)
+		writer := bufio.NewWriter(file)
+		defer func() {
+			if err := writer.Flush(); err != nil {
+				log.Printf("error writing %s: %s", filename, err.Error())
+			}
+			file.Close()
+		}()
+
+		// Flush on a timer rather than as records arrive, so that the last few don't sit in
+		// the buffer while a large file is hashed.
+		ticker := time.NewTicker(liveManifestFlush)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				writer.Flush()
+			case reply, ok := <-replies:
+				if !ok {
+					return
+				}
+				record, err := json.Marshal(HashRecord{Path: reply.Pathname, Size: reply.Size, ModTime: reply.ModTime, Hash: reply.Hash})
+				if err != nil {
+					log.Printf("error encoding %s: %s", reply.Pathname, err.Error())
+				} else {
+					writer.Write(append(record, '\n'))
+				}
+				tapped <- reply
+			}
+		}
+	}()
+
+	return tapped, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+// Seeding the aggregation from an external hash store, for --load-hashes.
+//
+// The file has one {path, size, mtime, hash} record per line, as written by --dump-hashes with
+// --format json; the array brackets and trailing commas of that output are tolerated. Files
+// found by the walk whose size and modification time match their record aren't read again,
+// and records for files the walk doesn't find at all, such as those hashed on another
+// machine, are taken as they are. Either way the hashes are only comparable if they were made
+// with the same options.
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+
+// loadedHashes are the records read by --load-hashes, keyed by path.
+var loadedHashes map[string]*CacheRecord
+
+// loadedFiles counts the walked files whose hash came from --load-hashes.
+var loadedFiles int64
+
+
+// loadHashes reads the records of a --load-hashes file, skipping any malformed lines.
+func loadHashes(filename string) error {
+	loadedHashes = make(map[string]*CacheRecord)
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ",")
+		if text == "" || text == "[" || text == "]" {
+			continue
+		}
+
+		record := &CacheRecord{}
+		decoder := json.NewDecoder(strings.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(record); err != nil {
+			log.Printf("%s:%d: skipping malformed record: %s", filename, line, err.Error())
+			continue
+		}
+		if record.Path == "" || record.Hash == "" || record.Size <= 0 {
+			log.Printf("%s:%d: skipping record without a path, size and hash", filename, line)
+			continue
+		}
+		loadedHashes[strings.ReplaceAll(record.Path, "\\", "/")] = record
+	}
+
+	return scanner.Err()
+}
+
+
+// isLoaded reports whether a walked file is unchanged since its hash was loaded, in which case
+// its record stands in for hashing it. Records of files which have changed are discarded.
+func isLoaded(request *FileHash) bool {
+	if loadedHashes == nil {
+		return false
+	}
+
+	key := strings.ReplaceAll(request.Pathname, "\\", "/")
+	record, ok := loadedHashes[key]
+	if !ok {
+		return false
+	}
+	if record.Size != request.Size || !record.ModTime.Equal(request.ModTime) {
+		delete(loadedHashes, key)
+		return false
+	}
+	loadedFiles++
+	return true
+}
+
+
+// seedTap forwards every reply on to the returned channel, followed by those of the loaded
+// records which are still valid once the walk is over.
+func seedTap(replies <-chan *FileHash) <-chan *FileHash {
+	tapped := make(chan *FileHash, cap(replies))
+
+	go func() {
+		defer close(tapped)
+		for reply := range replies {
+			tapped <- reply
+		}
+
+		// The replies are only closed once the walk has finished with loadedHashes.
+		paths := make([]string, 0, len(loadedHashes))
+		for path := range loadedHashes {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			record := loadedHashes[path]
+			tapped <- &FileHash{Pathname: record.Path, Size: record.Size, ModTime: record.ModTime, Hash: record.Hash}
+		}
+		log.Print("Loaded: ", len(loadedHashes), " records, ", loadedFiles, " of them for files found by the walk.")
+	}()
+
+	return tapped
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+
+// isLocked reports whether an error is because another process has the file locked, which is
+// only possible here with mandatory locking.
+func isLocked(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+
+// The Windows errors for files another process has open without sharing, or has locked.
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+
+// isLocked reports whether an error is because another process has the file open or locked.
+func isLocked(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
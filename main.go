@@ -6,16 +6,23 @@ package main
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"hash"
 	"io"
+	"io/fs"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	flag "github.com/spf13/pflag"
 )
@@ -27,10 +34,45 @@ type FileHash struct {
 	Pathname string
 	// Size is the size of the file in bytes.
 	Size int64
+	// ModTime is the modification time recorded when the file was discovered.
+	ModTime time.Time
+	// IsLink indicates that the link target rather than the content should be hashed.
+	IsLink bool
+	// Mode is the file's permission bits.
+	Mode os.FileMode
+	// Owner describes the file's uid and gid, where available.
+	Owner string
+	// Device is the id of the device the file is on, for --worker-affinity.
+	Device uint64
+	// CachedHash is the hash from the cache for files which haven't changed since.
+	CachedHash string
 	// Hash is where we'll the sha256 of the file.
 	Hash string
+	// Chunks are the content-defined chunks of the file, for --cdc.
+	Chunks []Chunk
+	// Members are the hashed members of an archive, for --into-archives.
+	Members []*FileHash
+	// Batch is the set of requests this one can collide with, if that is known.
+	Batch *Batch
 }
 
+
+// Batch tracks a set of requests whose hashes can only collide with each other, so that their
+// groups can be finalized as soon as the last of them has been hashed.
+type Batch struct {
+	// pending is how many of the requests have yet to be accounted for; it's updated by both
+	// the workers and the aggregator, so use atomic operations.
+	pending int64
+	// hashes lists the hash of each request that made it to the aggregator.
+	hashes []string
+}
+
+// startTime is when the run began, and walkTime and hashTime how long after it the walk and
+// the hashing were finished.
+var startTime time.Time
+var walkTime, hashTime time.Duration
+
+
 // CollisionTable is a dictionary of file-hash -> file-list
 type CollisionTable map[string][]string
 
@@ -40,78 +82,478 @@ var hashReqCh chan *FileHash
 // hashRepCh is the channel file hashes are returned to the main thread via.
 var hashRepCh chan *FileHash
 
-// workerGroup tracks how many workers are still waiting for requests to dry up.
-var workerGroup sync.WaitGroup
-
 // Assorted global counters.
-var totalFiles, underSizedFiles, hashingFiles int64
+var totalFiles, emptyFiles, underSizedFiles, hashingFiles, symlinkFiles, specialFiles, shortFiles int64
+
+// excludedFiles counts files and directories skipped by --exclude patterns.
+var excludedFiles int64
+
+// panickedFiles counts files whose hashing panicked; updated by the workers, so use atomic
+// operations.
+var panickedFiles int64
+
+// mutatedFiles counts files that changed while we were hashing them; it is
+// updated by the workers, so use atomic operations.
+var mutatedFiles int64
+
+// retriedFiles counts files which were only read successfully after retrying; it is updated by
+// the workers, so use atomic operations.
+var retriedFiles int64
+
+
+// openRange opens a file positioned at --offset, returning the file, for closing, and a reader
+// which is limited to --length bytes when a length was given, and to --max-read-rate, and
+// which skips the holes of sparse files with --sparse.
+func openRange(pathname string) (File, io.Reader, error) {
+	file, err := openFile(pathname)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if *Offset > 0 {
+		if _, err = file.Seek(*Offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+
+	reader := throttle(file)
+	if *Sparse && hasSparseSeek {
+		if reader, err = newSparseReader(file, reader); err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+	}
+
+	if *Length > 0 {
+		return file, io.LimitReader(reader, *Length), nil
+	}
+
+	return file, reader, nil
+}
 
 
 // hashData will execute a specific hashing algorithm against a file to produce the hash string.
 func hashData(pathname string, hasher hash.Hash) (string, error) {
-	file, err := os.Open(pathname)
+	digests, err := hashDigests(pathname, hasher)
 	if err != nil {
 		return "", err
 	}
+	return digests[0], nil
+}
+
+
+// hashDigests feeds a single read of a file to every one of the hashers, producing the hash
+// string of each in turn.
+func hashDigests(pathname string, hashers ...hash.Hash) ([]string, error) {
+	file, reader, err := openContent(pathname)
+	if err != nil {
+		return nil, err
+	}
 
 	defer file.Close()
 
-	// Try to read the file into the hasher to obtain the hash.
-	if _, err = io.Copy(hasher, file); err != nil {
+	return readerDigests(reader, hashers...)
+}
+
+
+// readerDigests feeds everything a reader has to every one of the hashers, producing the hash
+// string of each in turn.
+func readerDigests(reader io.Reader, hashers ...hash.Hash) ([]string, error) {
+	writers := make([]io.Writer, len(hashers))
+	for i, hasher := range hashers {
+		writers[i] = hasher
+	}
+
+	// Try to read the content into the hashers to obtain the hashes.
+	if _, err := io.Copy(io.MultiWriter(writers...), reader); err != nil {
+		return nil, err
+	}
+
+	digests := make([]string, len(hashers))
+	for i, hasher := range hashers {
+		digests[i] = hex.EncodeToString(hasher.Sum(nil))
+	}
+	return digests, nil
+}
+
+
+// digestString produces the hash string for some content from the digests that digest reads
+// it into, with the hashers the options and the pathname call for.
+func digestString(pathname string, digest func(hashers ...hash.Hash) ([]string, error)) (string, error) {
+	if *MultiAlgo {
+		// Two independent hashes from the one read, so that a collision would have to fool both.
+		digests, err := digest(sha256.New(), newBlake2b())
+		if err != nil {
+			return "", err
+		}
+		return multiAlgoPrefix + digests[0] + "." + digests[1], nil
+	}
+
+	// With --thorough, the md5 checksum is taken from the same read rather than a second one.
+	algorithm := algorithmFor(pathname)
+	hashers := []hash.Hash{algorithms[algorithm]()}
+	if *Thorough {
+		hashers = append(hashers, md5.New())
+	}
+	digests, err := digest(hashers...)
+	if err != nil {
+		return "", err
+	}
+
+	hashString := digests[0]
+	if algorithm != defaultAlgorithm {
+		// Keep the hashes of different algorithms apart.
+		hashString = algorithm + ":" + hashString
+	}
+	if *Thorough {
+		// Extend the fingerprint with the md5 checksum.
+		hashString += "." + digests[1]
+	}
+	return hashString, nil
+}
+
+
+// fingerprint produces the hash string for the content of a file.
+func fingerprint(pathname string) (string, error) {
+	if *ArchiveAware {
+		if hashString := archiveFingerprint(pathname); hashString != "" {
+			return hashString, nil
+		}
+	}
+
+	hashString, err := digestString(pathname, func(hashers ...hash.Hash) ([]string, error) {
+		return hashDigests(pathname, hashers...)
+	})
+	if err != nil {
 		return "", err
 	}
 
-	// Produce a size+hash combination to help bucketing.
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	// Fold in any alternate data streams.
+	streamsString, err := streamsFingerprint(pathname)
+	if err != nil {
+		return "", err
+	}
+	if streamsString != "" {
+		hashString += "." + streamsString
+	}
+
+	return hashString, nil
+}
+
+
+// isTransient reports whether an error might go away if the operation were retried; missing
+// files and permission problems won't.
+func isTransient(err error) bool {
+	return !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrPermission) && !errors.Is(err, fs.ErrInvalid)
+}
+
+
+// withRetries calls produce until it succeeds, fails with an error that isn't transient, or
+// has been retried --retries times, doubling the --retry-delay between each attempt.
+func withRetries(produce func() (string, error)) (string, error) {
+	delay := *RetryDelay
+	for attempt := 0; ; attempt++ {
+		result, err := produce()
+		if err == nil {
+			if attempt > 0 {
+				atomic.AddInt64(&retriedFiles, 1)
+			}
+			return result, nil
+		}
+		if attempt >= *Retries || !isTransient(err) {
+			return "", err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+
+// linkFingerprint produces the hash string for the target of a symlink.
+func linkFingerprint(pathname string) (string, error) {
+	target, err := fileSystem.Readlink(pathname)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha512.New()
+	io.WriteString(hasher, target)
+
+	return "link." + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+
+// hasMutated re-stats a file after hashing and reports whether its size or modification time
+// no longer match what was recorded in the request. The request is updated with the fresh
+// values so that a retry can compare against them.
+func hasMutated(pathname string, request *FileHash) (bool, error) {
+	stat := fileSystem.Stat
+	if request.IsLink {
+		stat = fileSystem.Lstat
+	}
+	info, err := stat(pathname)
+	if err != nil {
+		return false, err
+	}
+	if info.Size() == request.Size && info.ModTime().Equal(request.ModTime) {
+		return false, nil
+	}
+	request.Size, request.ModTime = info.Size(), info.ModTime()
+	return true, nil
 }
 
 
 // hashRequest will generate hash/hashes for individual files and populate the response.
 func hashRequest(request *FileHash) *FileHash {
-	pathname := strings.ReplaceAll(request.Pathname, "\\", "/")
-	hashString, err := hashData(pathname, sha512.New())
-	if err != nil {
-		log.Printf("error reading %s: %s", pathname, err.Error())
-		return nil
+	if request.CachedHash != "" {
+		request.Hash = request.CachedHash + metadataKey(request)
+		return request
 	}
 
-	if *Thorough {
-		// Extend the fingerprint with an md5 checksum.
-		md5String, err := hashData(pathname, md5.New())
+	pathname := strings.ReplaceAll(request.Pathname, "\\", "/")
+
+	var hashString string
+	for attempt := 0; ; attempt++ {
+		var err error
+		hashString, err = withRetries(func() (string, error) {
+			if request.IsLink {
+				return linkFingerprint(pathname)
+			}
+			return fingerprint(pathname)
+		})
+		if err != nil {
+			readError(pathname, err)
+			return nil
+		}
+
+		if *OnMutation == "ignore" {
+			break
+		}
+
+		// Make sure the file didn't change underneath us while we were reading it.
+		mutated, err := hasMutated(pathname, request)
 		if err != nil {
-			log.Printf("error re-reading %s: %s", pathname, err.Error())
+			fileError("re-checking", pathname, err)
 			return nil
 		}
-		hashString += "." + md5String
+		if !mutated {
+			break
+		}
+		if *OnMutation == "retry" && attempt == 0 {
+			continue
+		}
+
+		atomic.AddInt64(&mutatedFiles, 1)
+		log.Printf("%s changed while being hashed, discarding", pathname)
+		return nil
 	}
 
 	// Populate the request's Hash field and send it on to the reply channel.
 	request.Pathname = pathname
-	request.Hash = fmt.Sprintf("%016d.%s", request.Size, hashString)
+	if isArchiveHash(hashString) {
+		// Archives are compared on their members, whatever size their compression made them.
+		request.Hash = hashString + metadataKey(request)
+	} else {
+		request.Hash = sizeKey(request.Size) + hashString + metadataKey(request)
+	}
 
 	return request
 }
 
 
+// sizeKey is the front of the key of a request, its size, so that only files of the same size
+// can collide. --compare-contents-only drops it, for when --text-normalize means files of
+// different sizes can have the same content.
+func sizeKey(size int64) string {
+	if *ContentsOnly {
+		return ""
+	}
+	return fmt.Sprintf("%016d.", size)
+}
+
+
+// metadataKey describes the metadata that --include-mode, --include-owner and
+// --extension-sensitive add to the key of a request, so that files only collide if that
+// matches too.
+func metadataKey(request *FileHash) string {
+	key := ""
+	if *ExtensionSensitive {
+		key += ".ext-" + strings.ToLower(strings.TrimPrefix(filepath.Ext(request.Pathname), "."))
+	}
+	if *IncludeMode {
+		key += fmt.Sprintf(".mode-%o", request.Mode)
+	}
+	if *IncludeOwner && request.Owner != "" {
+		key += ".owner-" + request.Owner
+	}
+	return key
+}
+
+
+// Hasher is a function that populates the Hash of a request, returning nil if the file could
+// not be hashed.
+type Hasher func(request *FileHash) *FileHash
+
+
+// selectedHasher returns the Hasher for the mode chosen on the command line.
+func selectedHasher() Hasher {
+	switch {
+	case *Quick:
+		return quickRequest
+	case *TrustNameSize:
+		return nameSizeRequest
+	case *TrustMtimeSize:
+		return mtimeSizeRequest
+	case *EndsOnly:
+		return endsRequest
+	case *CDC:
+		return cdcRequest
+	}
+	return hashRequest
+}
+
+
 // hashingWorker will dispatch requests for file hashes and forward the responses to the replies
 // channel.
-func hashingWorker(requests <-chan *FileHash, replies chan<- *FileHash) {
+func hashingWorker(requests <-chan *FileHash, replies chan<- *FileHash, hasher Hasher, workerGroup *sync.WaitGroup) {
 	// Release our contribution from the pie on exit.
 	defer workerGroup.Done()
 
 	for request := range requests {
-		if reply := hashRequest(request); reply != nil {
+		waitIfPaused()
+		size := request.Size
+		var reply *FileHash
+		if !isInterrupted() {
+			reply = safeHash(hasher, request)
+		}
+		atomic.AddInt64(&completedFiles, 1)
+		atomic.AddInt64(&completedBytes, size)
+		if reply != nil {
 			replies <- reply
+		} else if request.Batch != nil {
+			// We won't be sending this one on, so don't keep the rest of the batch waiting.
+			atomic.AddInt64(&request.Batch.pending, -1)
+		}
+	}
+}
+
+
+// safeHash applies hasher to a request, turning a panic into an error for that file so that
+// one bad file can't take down the whole run, unless --panic-fatal.
+func safeHash(hasher Hasher, request *FileHash) (reply *FileHash) {
+	if !*PanicFatal {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				atomic.AddInt64(&panickedFiles, 1)
+				fileError("hashing", request.Pathname, fmt.Errorf("panic: %v", recovered))
+				reply = nil
+			}
+		}()
+	}
+	return hasher(request)
+}
+
+
+// shuffleBuffer holds requests waiting to be shuffled when --shuffle-window is in use.
+var shuffleBuffer []*FileHash
+
+
+// recentBuffer holds every request until the walk is done when --recent-first is in use.
+var recentBuffer []*FileHash
+
+
+// dispatch forwards a request to the workers, or, with --shuffle-window, collects a window
+// of them and sends them in a random order to spread the reads around the tree.
+func dispatch(request *FileHash) {
+	waitIfPaused()
+	if *RecentFirst {
+		recentBuffer = append(recentBuffer, request)
+		return
+	}
+	if *ShuffleWindow <= 0 {
+		hashReqCh <- request
+		return
+	}
+
+	shuffleBuffer = append(shuffleBuffer, request)
+	if len(shuffleBuffer) >= *ShuffleWindow {
+		flushShuffle()
+	}
+}
+
+
+// flushShuffle sends whatever is in the shuffle buffer to the workers in random order.
+func flushShuffle() {
+	rand.Shuffle(len(shuffleBuffer), func(i, j int) {
+		shuffleBuffer[i], shuffleBuffer[j] = shuffleBuffer[j], shuffleBuffer[i]
+	})
+	for _, request := range shuffleBuffer {
+		hashReqCh <- request
+	}
+	shuffleBuffer = shuffleBuffer[:0]
+}
+
+
+// flushRecent sends everything held back by --recent-first to the workers, most recently
+// modified first.
+func flushRecent() {
+	sort.SliceStable(recentBuffer, func(i, j int) bool {
+		return recentBuffer[i].ModTime.After(recentBuffer[j].ModTime)
+	})
+	for _, request := range recentBuffer {
+		hashReqCh <- request
+	}
+	recentBuffer = nil
+}
+
+
+// isOneOf reports whether value is in the list of choices.
+func isOneOf(value string, choices []string) bool {
+	for _, choice := range choices {
+		if value == choice {
+			return true
 		}
 	}
+	return false
+}
+
+
+// isTopLevel reports whether path is a direct child of one of the roots.
+func isTopLevel(path string) bool {
+	return isRoot(filepath.Dir(path))
 }
 
 
 // walkFn will receive paths from filepath.Walk and dispatch them as requests to the request
 // workers via the requests channel.
 func walkFn(path string, info os.FileInfo, fileErr error) (err error) {
-	// Ignore directories.
-	if info.IsDir() {
+	if isInterrupted() {
+		return errInterrupted
+	}
+
+	// Skip over anything excluded, and everything under excluded directories, as well as the
+	// files this run is writing.
+	if (isExcluded(path) && !isRoot(path)) || isOwnFile(path, info) {
+		excludedFiles++
+		if info != nil && info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}
+
+	// Ignore directories. There is no info at all if the path couldn't be looked at. Roots
+	// nested inside the one being walked are left for their own walk, so that each file is
+	// attributed to the deepest root it is under and only seen once, and so is anything
+	// reached a second time under another path.
+	if info != nil && info.IsDir() {
+		if isHiddenDir(path) {
+			excludedFiles++
+			return filepath.SkipDir
+		}
+		if isOtherRoot(path) || isRevisit(path, info) {
+			return filepath.SkipDir
+		}
 		return
 	}
 
@@ -119,22 +561,114 @@ func walkFn(path string, info os.FileInfo, fileErr error) (err error) {
 
 	// If there was a problem accessing the file, ignore it.
 	if fileErr != nil {
+		recordError(path, fileErr)
+		return
+	}
+
+	// The same file seen again through a root given in a different case isn't a duplicate.
+	if isAliased(path) {
 		return
 	}
 
-	// Ignore zero-length files.
-	if info.Size() == 0 || info.Size() < int64(*MinBytes) {
+	// filepath.Walk uses Lstat, so symlinks are reported as the link itself.
+	isLink := false
+	if info.Mode()&os.ModeSymlink != 0 && *FollowTopSymlinks && isTopLevel(path) {
+		target, err := fileSystem.Stat(path)
+		if err != nil {
+			symlinkFiles++
+			return nil
+		}
+		if target.IsDir() {
+			// A trailing separator makes Walk's Lstat resolve the link, while the paths
+			// underneath are still reported relative to the link itself.
+			return fileSystem.Walk(path+string(filepath.Separator), walkFn)
+		}
+		info = target
+	} else if info.Mode()&os.ModeSymlink != 0 {
+		switch *SymlinkMode {
+		case "follow":
+			// Hash the content of the target, but don't descend into linked directories.
+			target, err := fileSystem.Stat(path)
+			if err != nil || target.IsDir() {
+				symlinkFiles++
+				return nil
+			}
+			info = target
+		case "hash-link":
+			isLink = true
+		default:
+			symlinkFiles++
+			return nil
+		}
+	}
+
+	// Pipes, sockets and devices could block a worker forever, so only hash regular files.
+	if !isLink && !info.Mode().IsRegular() {
+		specialFiles++
+		return nil
+	}
+
+	// Ignore zero-length files unless asked not to, and files under MinBytes; link targets are
+	// always short, so don't hold them to it.
+	if !isLink && info.Size() == 0 {
+		if !*IncludeEmpty {
+			emptyFiles++
+			return
+		}
+	} else if !isLink && info.Size() < int64(*MinBytes) {
 		underSizedFiles++
 		return
 	}
 
-	hashingFiles++
+	// When only a range of the file is being compared, the file has to actually contain it.
+	if !isLink && (*Offset > 0 || *Length > 0) {
+		if info.Size() <= *Offset || info.Size() < *Offset+*Length {
+			shortFiles++
+			if *RangeStrict {
+				log.Printf("error: %s is too short (%d bytes) for the requested range", path, info.Size())
+			}
+			return nil
+		}
+	}
+
+	// With --sample-rate, only files of some sizes are hashed.
+	if !isLink && !isSampled(info.Size()) {
+		sampledOutFiles++
+		return nil
+	}
+
+	// Sniffing the type means reading the start of the file, so do it after the cheaper checks.
+	if !isLink && isSkippedType(path) {
+		return nil
+	}
 
 	request := &FileHash{
 		Pathname: path,
 		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		IsLink:   isLink,
+		Mode:     info.Mode().Perm(),
+	}
+	if !isLink && isLoaded(request) {
+		return nil
+	}
+
+	atomic.AddInt64(&hashingFiles, 1)
+	atomic.AddInt64(&dispatchedBytes, info.Size())
+	if *IncludeOwner {
+		request.Owner = fileOwner(info)
 	}
-	hashReqCh <- request
+	if *WorkerAffinity {
+		request.Device = fileDevice(info)
+	}
+	if cachedHashes != nil {
+		request.CachedHash = lookupCache(&FileHash{
+			Pathname: strings.ReplaceAll(path, "\\", "/"),
+			Size:     request.Size,
+			ModTime:  request.ModTime,
+		})
+	}
+	dispatch(request)
 
 	return nil
 }
@@ -142,14 +676,22 @@ func walkFn(path string, info os.FileInfo, fileErr error) (err error) {
 
 // workers creates all of the hashing threads in the background and closes the
 // reply channel once they have all exited.
-func workers(requests <-chan *FileHash, replies chan<- *FileHash) {
+func workers(requests <-chan *FileHash, replies chan<- *FileHash, hasher Hasher) {
 	// When we exit scope, close the reply channel.
 	defer close(replies)
 
+	if *WorkerAffinity && hasDevices {
+		deviceWorkers(requests, replies, hasher)
+		return
+	}
+
+	// workerGroup tracks how many workers are still waiting for requests to dry up.
+	var workerGroup sync.WaitGroup
+
 	// Create workers to consume requests.
 	workerGroup.Add(*Threads)
 	for i := 0; i < *Threads; i++ {
-		go hashingWorker(requests, replies)
+		go hashingWorker(requests, replies, hasher, &workerGroup)
 	}
 
 	// Wait for all the workers to exit.
@@ -157,81 +699,483 @@ func workers(requests <-chan *FileHash, replies chan<- *FileHash) {
 }
 
 
+// deviceQueue is how many requests can wait for each device's workers before the rest have to
+// wait for them too.
+const deviceQueue = 4096
+
+
+// deviceWorkers gives each device its own pool of --device-threads workers, so that the disks
+// of a JBOD are all kept busy instead of every worker queuing on whichever disk is slowest.
+func deviceWorkers(requests <-chan *FileHash, replies chan<- *FileHash, hasher Hasher) {
+	var workerGroup sync.WaitGroup
+
+	// Route each request to its device's pool, starting the pool the first time the device
+	// is seen.
+	pools := make(map[uint64]chan *FileHash)
+	for request := range requests {
+		pool, ok := pools[request.Device]
+		if !ok {
+			pool = make(chan *FileHash, deviceQueue)
+			pools[request.Device] = pool
+			workerGroup.Add(*DeviceThreads)
+			for i := 0; i < *DeviceThreads; i++ {
+				go hashingWorker(pool, replies, hasher, &workerGroup)
+			}
+		}
+		pool <- request
+	}
+
+	for _, pool := range pools {
+		close(pool)
+	}
+	workerGroup.Wait()
+}
+
+
 // walkFiles walks the file system and closes the request channel once it
 // has seen everything.
 func walkFiles(requests chan<- *FileHash) {
 	// When we exit, close the request channel.
 	defer close(requests)
+	defer close(walkDone)
 
 	// Start dispatching requests.
-	filepath.Walk(*BasePath, walkFn)
+	if *SizeIndex != "" {
+		walkSizeIndex()
+	} else {
+		walk := fileSystem.Walk
+		if *SizeIndexCache != "" {
+			walk = walkListings
+		}
+		for _, root := range roots {
+			walkingRoot = root
+			walk(root, walkFn)
+		}
+		if *SizeIndexCache != "" {
+			if err := saveListings(*SizeIndexCache); err != nil {
+				log.Printf("error writing size index cache %s: %s", *SizeIndexCache, err.Error())
+			}
+		}
+	}
+	flushShuffle()
+	flushRecent()
+	walkTime = time.Since(startTime)
 
-	log.Print("Total Files:", totalFiles, ", Undersized:", underSizedFiles, ", Hashing:", hashingFiles)
+	log.Print("Total Files:", totalFiles, ", Empty:", emptyFiles, ", Undersized:", underSizedFiles, ", Excluded:", excludedFiles, ", Symlinks skipped:", symlinkFiles, ", Special:", specialFiles, ", Short:", shortFiles, ", Hashing:", hashingFiles)
+	if *SampleRate < 1 {
+		log.Print("Sampled: ", sampledOutFiles, " files left out by --sample-rate")
+	}
+	reportSkippedTypes()
+	reportAliases()
+}
+
+
+// summarizesGroups reports whether anything after the aggregation looks at every group, which
+// it couldn't do if groups were emitted, and dropped from the table, as they were completed.
+func summarizesGroups() bool {
+	return heuristicMode() != "" || *RankDirs || *ByCategory || *CDC || *LinkCheck || *DiskUsage || *SampleRate < 1
 }
 
 
 // aggregateHashes will collect results from the reply channel and bucket filenames together
 // by hash, elimiating those cases where only one file had a hash (ie it was distinct).
-func aggregateHashes(replies <-chan *FileHash) CollisionTable {
-	// Create dictionaries that map a file hash to a list of path names.
-	// We use two dictionaries so we can filter out entries that only have
-	// one file - ie nobody matched them.
-	singles := make(CollisionTable)
-	collisions := make(CollisionTable)
+//
+// If emit is not nil, the collisions of each Batch are passed to it and removed from the
+// table as soon as the whole batch has been seen, rather than waiting for everything.
+func aggregateHashes(replies <-chan *FileHash, emit func(hash string, files []string)) CollisionTable {
+	// A single dictionary maps each file-hash to its path names; buckets with only one file
+	// - ie nobody matched them - are dropped at the end, rather than moving every bucket from
+	// a table of singles to one of collisions when its second file turns up.
+	buckets := make(CollisionTable)
+	var hashedFiles int64
+
+	// Tallies of what was emitted early, for the summary.
+	var emittedSingles, emittedHashes int
 
 	for response := range replies {
-		_, exists := collisions[response.Hash]
-		if exists {
-			collisions[response.Hash] = append(collisions[response.Hash], response.Pathname)
+		hashedFiles++
+		buckets[response.Hash] = append(buckets[response.Hash], response.Pathname)
+
+		if emit == nil || response.Batch == nil {
 			continue
 		}
-		_, exists = singles[response.Hash]
-		if exists {
-			collisions[response.Hash] = append(singles[response.Hash], response.Pathname)
-			delete(singles, response.Hash)
+
+		// Once the whole batch is in, its groups can't grow any further.
+		batch := response.Batch
+		batch.hashes = append(batch.hashes, response.Hash)
+		if atomic.AddInt64(&batch.pending, -1) != 0 {
 			continue
 		}
-		singles[response.Hash] = []string{response.Pathname}
+		for _, hash := range batch.hashes {
+			files, exists := buckets[hash]
+			if !exists {
+				continue
+			}
+			if len(files) > 1 {
+				emit(hash, files)
+				emittedHashes++
+			} else {
+				emittedSingles++
+			}
+			delete(buckets, hash)
+		}
 	}
 
-	collidingFiles := hashingFiles - int64(len(singles))
-	duplicates := collidingFiles - int64(len(collisions))
+	singles := 0
+	for hash, files := range buckets {
+		if len(files) < 2 {
+			delete(buckets, hash)
+			singles++
+		}
+	}
 
-	log.Print("Misses:", len(singles), ", Collisions:", collidingFiles, ", Hashes:", len(collisions), ", Dupes:", duplicates)
+	logAggregate(hashedFiles, singles+emittedSingles, len(buckets)+emittedHashes)
 
-	return collisions
+	return buckets
 }
 
 
-// reportCollisions will output a report of which files collided.
-func reportCollisions(collisions CollisionTable) {
-	for _, files := range collisions {
-		for _, file := range files {
-			fmt.Printf(" ")
-			fmt.Printf("%q", file)
-		}
-		fmt.Printf("\n")
+// logAggregate logs the summary of what the aggregation found.
+func logAggregate(hashedFiles int64, misses, hashes int) {
+	collidingFiles := hashedFiles - int64(misses)
+	duplicates := collidingFiles - int64(hashes)
+
+	log.Print("Misses:", misses, ", Collisions:", collidingFiles, ", Hashes:", hashes, ", Dupes:", duplicates)
+	if *IntoArchives {
+		logMembers()
+	}
+	if retriedFiles > 0 {
+		log.Print("Retried:", retriedFiles, " files were only read after retrying.")
+	}
+	if mutatedFiles > 0 {
+		log.Print("Mutated:", mutatedFiles, " files changed during hashing; results may be stale.")
+	}
+	if panickedFiles > 0 {
+		log.Print("Panicked:", panickedFiles, " files crashed their worker and were skipped.")
+	}
+	if lockedFiles > 0 {
+		log.Print("Locked:", lockedFiles, " files were in use by other processes and couldn't be read.")
+	}
+}
+
+
+// logTiming logs how long the run took, and when the walk and hashing finished during it,
+// unless the log is meant to be repeatable.
+func logTiming() {
+	if *Deterministic {
+		return
+	}
+	elapsed := time.Since(startTime).Round(time.Millisecond)
+	if hashTime == 0 {
+		log.Print("Elapsed: ", elapsed)
+		return
 	}
+	log.Print("Elapsed: ", elapsed, " (walk done after ", walkTime.Round(time.Millisecond),
+		", hashing after ", hashTime.Round(time.Millisecond), ")")
 }
 
 
+
 func main() {
 	var collisions CollisionTable
 
-	flag.Parse()
-	if len(flag.Args()) > 0 {
-		fmt.Fprintf(os.Stderr, "\x1b[31mERROR: Unexpected argument: %s. Did you mean '--path' or is there a space in your path name?\x1b[39m\n", flag.Args()[0])
-		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
-		flag.PrintDefaults()
-		return
+	startTime = time.Now()
+
+	args, err := expandResponseFiles(os.Args[1:], 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\x1b[31mERROR: %s\x1b[39m\n", err.Error())
+		os.Exit(1)
+	}
+	flag.CommandLine.Parse(args)
+
+	// Only a --path given on the command line is a root alongside the arguments.
+	pathGiven := flag.CommandLine.Changed("path")
+	if err := loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "\x1b[31mERROR: --config: %s\x1b[39m\n", err.Error())
+		os.Exit(1)
 	}
+	setRoots(flag.Args(), pathGiven)
 
+	if *AutoThreads {
+		if flag.CommandLine.Changed("threads") {
+			panic("--auto-threads can't be combined with --threads")
+		}
+		*Threads = autoThreads()
+	}
 	if *Threads < 1 {
 		panic("--threads/-j must be >= 1")
 	}
+	if *Deterministic {
+		// One worker hashes everything in the order the walk found it, and the log lines
+		// don't carry the time.
+		*Threads, *WorkerAffinity, *ShuffleWindow = 1, false, 0
+		log.SetFlags(0)
+	}
+	if *CrossRootOnly && len(roots) < 2 {
+		panic("--cross-root-only needs at least two root paths")
+	}
+	if *LimitGroups < 0 {
+		panic("--limit-groups must be >= 0")
+	}
+	if *Spill < 0 {
+		panic("--spill must be >= 0")
+	}
+	if *MinCount < 2 {
+		panic("--min-count must be >= 2")
+	}
+	if *DeviceThreads < 1 {
+		panic("--device-threads must be >= 1")
+	}
 	if *MinBytes < 0 {
 		*MinBytes = 0
 	}
+	if *MaxReadRate > 0 {
+		readLimiter = newRateLimiter(*MaxReadRate)
+	}
+	if *MaxOpenFiles < 0 {
+		panic("--max-open-files must be >= 0")
+	}
+	if *Verify && *MaxOpenFiles == 1 {
+		panic("--verify compares files in pairs, so needs --max-open-files of at least 2")
+	}
+	limitOpenFiles()
+	if *Retries < 0 {
+		*Retries = 0
+	}
+	// Going by the values rather than what was given on the command line, so that either may
+	// come from the config file.
+	if *SkipHeaderBytes != 0 {
+		if *Offset != 0 {
+			panic("--skip-header-bytes and --offset are the same thing; give only one")
+		}
+		*Offset = *SkipHeaderBytes
+	}
+	if *Offset < 0 || *Length < 0 {
+		panic("--offset and --length must be >= 0")
+	}
+	switch *Format {
+	case "text", "json", "json-stream", "json-tree", "yaml", "fdupes", "flat-sorted":
+	default:
+		panic("--format must be one of text, json, json-stream, json-tree, yaml, fdupes or flat-sorted")
+	}
+	if err := validateAction(); err != nil {
+		panic(err.Error())
+	}
+	if !isOneOf(*Keep, keepStrategies) {
+		panic("--keep must be one of " + strings.Join(keepStrategies, ", "))
+	}
+	if !isOneOf(*OnActionError, actionErrorModes) {
+		panic("--on-action-error must be abort or continue")
+	}
+	if err := parseAlgoMap(); err != nil {
+		panic("--algo-map: " + err.Error())
+	}
+	if !isOneOf(*FileSort, fileSorts) {
+		panic("--file-sort must be one of path, mtime or size")
+	}
+	if err := loadExcludes(); err != nil {
+		panic("--exclude-from: " + err.Error())
+	}
+	if *Quick && *Fast {
+		panic("--quick and --fast are mutually exclusive")
+	}
+	if *Quick && !*Verify && (selectedAction() != "" || *PlanOutput != "") {
+		panic("--quick needs --verify before acting on what it finds")
+	}
+	if *Dirs && (*Fast || *DumpHashes || selectedAction() != "" || *PlanOutput != "") {
+		panic("--dirs can't be combined with --fast, --dump-hashes, --plan-output, --delete, --hardlink or --move-to")
+	}
+	if *ApplyPlan != "" && (selectedAction() != "" || *PlanOutput != "") {
+		panic("--apply-plan carries out the plan's own action, so can't be combined with --plan-output, --delete, --hardlink or --move-to")
+	}
+	if *TrustNameSize && (*Quick || *Fast || *LoadHashes != "" || *Dirs) {
+		panic("--trust-name-size can't be combined with --quick, --fast, --load-hashes or --dirs")
+	}
+	if *TrustNameSize && !*Verify && (selectedAction() != "" || *PlanOutput != "") {
+		panic("--trust-name-size needs --verify before acting on what it finds")
+	}
+	if *TrustMtimeSize && (*Quick || *Fast || *TrustNameSize || *LoadHashes != "" || *Dirs) {
+		panic("--trust-mtime-size can't be combined with --quick, --fast, --trust-name-size, --load-hashes or --dirs")
+	}
+	if *TrustMtimeSize && !*Verify && (selectedAction() != "" || *PlanOutput != "") {
+		panic("--trust-mtime-size needs --verify before acting on what it finds")
+	}
+	if *EndsOnly && (*Quick || *Fast || *TrustNameSize || *TrustMtimeSize || *ArchiveAware || *TextNormalize || *Offset > 0 || *Length > 0) {
+		panic("--ends-only can't be combined with --quick, --fast, --trust-name-size, --trust-mtime-size, --archive-aware, --text-normalize, --offset or --length")
+	}
+	if *EndsOnly && !*Verify && (selectedAction() != "" || *PlanOutput != "") {
+		panic("--ends-only needs --verify before acting on what it finds")
+	}
+	if *BlockSize < 1 {
+		panic("--block-size must be >= 1")
+	}
+	if *VerifyChecksums != "" && (*Offset > 0 || *Length > 0 || *TextNormalize || selectedAction() != "" || *PlanOutput != "" || *ApplyPlan != "") {
+		panic("--verify-checksums can't be combined with --offset, --length, --text-normalize, an action or a plan")
+	}
+	if *CDC && (*Quick || *Fast || *TrustNameSize || *TrustMtimeSize || *EndsOnly || *ArchiveAware || *SinceLastRun || *LoadHashes != "" || *DumpHashes) {
+		panic("--cdc can't be combined with --quick, --fast, --trust-name-size, --trust-mtime-size, --ends-only, --archive-aware, --since-last-run, --load-hashes or --dump-hashes")
+	}
+	if *Serve != "" && (*DumpHashes || selectedAction() != "" || *PlanOutput != "") {
+		panic("--serve can't be combined with --dump-hashes, an action or --plan-output")
+	}
+	if *TreeHash && (*Fast || *DumpHashes) {
+		panic("--tree-hash can't be combined with --fast or --dump-hashes")
+	}
+	if *TreeHashContentOnly && !*TreeHash {
+		panic("--tree-hash-content-only requires --tree-hash")
+	}
+	if *LinkCheck && *Dirs {
+		panic("--link-check can't be combined with --dirs")
+	}
+	if *MultiAlgo && (*Thorough || *AlgoMap != "" || *Quick || *TrustNameSize || *TrustMtimeSize || *EndsOnly || *CDC) {
+		panic("--multi-algo can't be combined with --thorough, --algo-map, --quick, --trust-name-size, --trust-mtime-size, --ends-only or --cdc")
+	}
+	if *ByCategory && *Dirs {
+		panic("--by-category can't be combined with --dirs")
+	}
+	if *RankDirs && *Dirs {
+		panic("--rank-dirs can't be combined with --dirs")
+	}
+	if *ArchiveAware && (*Fast || *Quick || *TrustNameSize || *TrustMtimeSize) {
+		panic("--archive-aware can't be combined with --fast, --quick, --trust-name-size or --trust-mtime-size")
+	}
+	if *IntoArchives && (*Fast || *Quick || *TrustNameSize || *TrustMtimeSize || *EndsOnly || *CDC || *ArchiveAware || *TextNormalize || *Offset > 0 || *Length > 0 || *Dirs || *TreeHash || *Verify || selectedAction() != "" || *PlanOutput != "") {
+		panic("--into-archives can't be combined with --fast, --quick, --trust-name-size, --trust-mtime-size, --ends-only, --cdc, --archive-aware, --text-normalize, --offset, --length, --dirs, --tree-hash, --verify, an action or --plan-output")
+	}
+	if *SampleRate <= 0 || *SampleRate > 1 {
+		panic("--sample-rate must be more than 0 and at most 1")
+	}
+	if *SampleRate < 1 && (*Cache != "" || *LoadHashes != "" || *SizeIndex != "" || *IntoArchives || *Dirs || *TreeHash) {
+		panic("--sample-rate can't be combined with --cache, --load-hashes, --size-index, --into-archives, --dirs or --tree-hash")
+	}
+	if *ContentsOnly && *Fast {
+		panic("--compare-contents-only can't be used with --fast, which only compares files of the same size")
+	}
+	if *RecentFirst && (*ShuffleWindow > 0 || *SizeIndex != "") {
+		panic("--recent-first can't be combined with --shuffle-window or --size-index")
+	}
+	if *ShuffleWindow > 0 {
+		rand.Seed(time.Now().UnixNano())
+	}
+	if err := parseSeparators(); err != nil {
+		panic(err.Error())
+	}
+	if err := parseTemplate(); err != nil {
+		panic("--template: " + err.Error())
+	}
+	if *ReportFilterAll && *ReportFilter == "" && len(*ReportExtensions) == 0 {
+		panic("--report-filter-all requires --report-filter or --report-ext")
+	}
+	if err := parseReportFilter(); err != nil {
+		panic("--report-filter: " + err.Error())
+	}
+	switch *SymlinkMode {
+	case "skip", "follow", "hash-link":
+	default:
+		panic("--symlink-mode must be one of skip, follow or hash-link")
+	}
+	switch *OnMutation {
+	case "retry", "discard", "ignore":
+	default:
+		panic("--on-mutation must be one of retry, discard or ignore")
+	}
+
+	if *LoadHashes != "" {
+		if *Fast || *Quick {
+			panic("--load-hashes can't be combined with --fast or --quick")
+		}
+		if err := loadHashes(*LoadHashes); err != nil {
+			panic("--load-hashes: " + err.Error())
+		}
+	}
+	if *SizeIndex != "" {
+		if *Fast || *SinceLastRun || *LoadHashes != "" || *WorkerAffinity || *CaseInsensitiveFS {
+			panic("--size-index can't be combined with --fast, --since-last-run, --load-hashes, --worker-affinity or --case-insensitive-fs")
+		}
+		if err := loadSizeIndex(*SizeIndex); err != nil {
+			panic("--size-index: " + err.Error())
+		}
+	}
+	if *SizeIndexCache != "" {
+		if *Fast || *SizeIndex != "" || *LoadHashes != "" || *WorkerAffinity {
+			panic("--size-index-cache can't be combined with --fast, --size-index, --load-hashes or --worker-affinity")
+		}
+		if err := loadListings(*SizeIndexCache); err != nil {
+			panic("--size-index-cache: " + err.Error())
+		}
+	}
+	if *SinceLastRun {
+		if *Cache == "" {
+			panic("--since-last-run requires --cache")
+		}
+		if err := loadCache(*Cache); err != nil {
+			panic("--cache: " + err.Error())
+		}
+	}
+
+	if *Benchmark {
+		if *BenchmarkSize < 1 {
+			panic("--benchmark-size must be >= 1")
+		}
+		if err := runBenchmark(); err != nil {
+			panic("--benchmark: " + err.Error())
+		}
+		return
+	}
+
+	defer reportSuppressedErrors()
+
+	// Applying a plan doesn't need a walk; the plan already says what to act on.
+	if *ApplyPlan != "" {
+		if *ErrorsOutput != "" {
+			defer func() {
+				if err := writeErrors(*ErrorsOutput); err != nil {
+					log.Printf("error writing %s: %s", *ErrorsOutput, err.Error())
+				}
+			}()
+		}
+		if err := applyPlan(*ApplyPlan); err != nil {
+			panic("--apply-plan: " + err.Error())
+		}
+		return
+	}
+
+	// Two files rather than directories are just compared with each other.
+	if first, second, ok := twoFiles(); ok {
+		same, err := compareTwoFiles(first, second)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\x1b[31mERROR: %s\x1b[39m\n", err.Error())
+			os.Exit(2)
+		}
+		if !same {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Catch typos up front rather than quietly finding nothing.
+	if err := checkRoots(); err != nil {
+		fmt.Fprintf(os.Stderr, "\x1b[31mERROR: %s\x1b[39m\n", err.Error())
+		os.Exit(1)
+	}
+
+	noteOwnFiles()
+
+	if *VerifyChecksums != "" {
+		ok, err := verifyChecksums(*VerifyChecksums)
+		if err != nil {
+			panic("--verify-checksums: " + err.Error())
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	defer logTiming()
+
+	watchPauseSignals()
+	watchInterrupts()
 
 	// Create the request and reply channels.
 	hashReqCh, hashRepCh = make(chan *FileHash, 65536), make(chan *FileHash, *Threads * 2)
@@ -239,16 +1183,197 @@ func main() {
 	// Execute 'walkFiles' in the background.
 	go walkFiles(hashReqCh)
 
-	// Launch and manage the workers in the background.
-	go workers(hashReqCh, hashRepCh)
+	if *Fast {
+		// Narrow the field down by size and then by the file heads before full hashing.
+		fastPipeline(hashReqCh, hashRepCh)
+	} else {
+		// Launch and manage the workers in the background.
+		hasher := selectedHasher()
+		if *IntoArchives {
+			hasher = memberHasher(hasher)
+		}
+		if *SizeIndex != "" {
+			hasher = sizeIndexHasher(hasher)
+		}
+		if *SizeIndexCache != "" {
+			hasher = listingHasher(hasher)
+		}
+		go workers(hashReqCh, hashRepCh, hasher)
+	}
+
+	if *ErrorsOutput != "" {
+		defer func() {
+			if err := writeErrors(*ErrorsOutput); err != nil {
+				log.Printf("error writing %s: %s", *ErrorsOutput, err.Error())
+			}
+		}()
+	}
+
+	// Keep a record of every hash for the cache on the way past.
+	var replies <-chan *FileHash = hashRepCh
+	if loadedHashes != nil {
+		replies = seedTap(replies)
+	}
+	if *LiveManifest != "" {
+		var err error
+		if replies, err = liveManifestTap(replies, *LiveManifest); err != nil {
+			panic("--live-manifest: " + err.Error())
+		}
+	}
+	if *Cache != "" {
+		replies = cacheTap(replies)
+		defer func() {
+			if err := saveCache(*Cache); err != nil {
+				log.Printf("error writing cache %s: %s", *Cache, err.Error())
+			}
+		}()
+	}
+
+	if *IntoArchives {
+		replies = memberTap(replies)
+	}
+
+	if *Histogram {
+		replies = histogramTap(replies)
+	}
+
+	// The search for partial duplicates needs the chunks of every file.
+	if *CDC {
+		replies = cdcTap(replies)
+	}
+
+	// Duplicate directories need the hash of every file, not just the ones that collide.
+	if *Dirs {
+		replies = dirTap(replies)
+	}
+
+	// So does the fingerprint of the tree.
+	if *TreeHash {
+		replies = treeTap(replies)
+	}
 
-	// Collect results from workers into an aggregate representation.
-	collisions = aggregateHashes(hashRepCh)
-	if len(collisions) == 0 {
+	// Dumping bypasses the aggregation entirely and streams every hash as it arrives.
+	if *DumpHashes {
+		dumpHashes(replies)
+		hashTime = time.Since(startTime)
 		return
 	}
 
+	// Collect results from workers into an aggregate representation. When streaming, groups
+	// which are known to be complete are written out as soon as they are.
+	var writer *groupWriter
+	var emit func(hash string, files []string)
 	if *ListCollisions {
-		reportCollisions(collisions)
+		writer = newGroupWriter()
+		if *Format == "json-stream" && selectedAction() == "" && *PlanOutput == "" && *Serve == "" && !*Dirs && !*Verify && *Spill == 0 && *LimitGroups == 0 && !summarizesGroups() {
+			emit = func(hash string, files []string) {
+				if group := newGroup(hash, files); isReportable(group) {
+					writer.write(group)
+				}
+			}
+		}
+	}
+	var bar *progressBar
+	if *ProgressBar {
+		bar = startProgress()
+	}
+	if *Spill > 0 {
+		if collisions, err = spillAggregate(replies, *Spill, *SpillDir); err != nil {
+			panic("--spill: " + err.Error())
+		}
+	} else {
+		collisions = aggregateHashes(replies, emit)
+	}
+	hashTime = time.Since(startTime)
+	if bar != nil {
+		bar.finish()
+	}
+	if isInterrupted() {
+		// Finish off whatever was streamed so that it is still well formed.
+		if emit != nil {
+			writer.close()
+		}
+		log.Print("Interrupted: the results are incomplete, so nothing more was reported or acted on")
+		return
+	}
+	if *Histogram {
+		logHistogram()
+	}
+	if *Sparse {
+		logHoles()
+	}
+	if *Verify {
+		verifyCollisions(collisions)
+	} else if *Quick {
+		log.Print("Approximate: --quick only compared sizes and the first ", QuickBytes/1024, "KiB of each file.")
+	} else if *TrustNameSize {
+		log.Print("Unverified: --trust-name-size only compared names and sizes, not content; add --verify to check.")
+	} else if *TrustMtimeSize {
+		log.Print("Unverified: --trust-mtime-size only compared modification times and sizes, not content; add --verify to check.")
+	} else if *EndsOnly {
+		log.Print("Approximate: --ends-only only compared sizes and the first and last ", *BlockSize, " bytes of each file; add --verify to check.")
+	}
+	if heuristicMode() != "" {
+		checkSplitLinks(collisions)
+	}
+
+	if *RankDirs {
+		logDirRanking(collisions)
+	}
+	if *ByCategory {
+		logCategories(collisions)
+	}
+	if *CDC {
+		logPartialDuplicates(collisions)
+	}
+	if *LinkCheck {
+		logLinkCheck(collisions)
+	}
+	if *DiskUsage {
+		logDiskUsage(collisions)
+	}
+	if *TreeHash {
+		logTreeHash()
+	}
+	if *SampleRate < 1 {
+		logSampleEstimate(collisions)
+	}
+
+	if *Serve != "" {
+		var groups []Group
+		if *Dirs {
+			groups = duplicateDirs()
+		} else {
+			groups = sortedGroups(collisions)
+		}
+		if err := serveGroups(*Serve, groups); err != nil {
+			log.Printf("error serving on %s: %s", *Serve, err.Error())
+		}
+	} else if *Dirs {
+		groups := duplicateDirs()
+		if *ListCollisions {
+			reportCollisions(feedGroups(limitGroups(groups)), writer)
+		}
+	} else if action := selectedAction(); action != "" || *PlanOutput != "" {
+		// The groups have to outlive the report for the action to use them.
+		groups := sortedGroups(collisions)
+		if *ListCollisions {
+			reportCollisions(feedGroups(limitGroups(append([]Group(nil), groups...))), writer)
+		}
+		if *PlanOutput != "" {
+			if err := writePlan(*PlanOutput, action, groups); err != nil {
+				log.Printf("error writing plan %s: %s", *PlanOutput, err.Error())
+			}
+		} else {
+			performAction(action, groups)
+		}
+	} else if *ListCollisions && *LimitGroups > 0 {
+		reportCollisions(feedGroups(limitGroups(sortedGroups(collisions))), writer)
+	} else if *ListCollisions {
+		reportCollisions(streamGroups(collisions), writer)
+	}
+
+	if *ReportEmptyDirs || *PruneEmptyDirs {
+		reportEmptyDirs()
 	}
 }
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha512"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	flag "github.com/spf13/pflag"
+)
+
+
+// setFlag sets an option for the rest of a test, as if it had been given on the command line.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	option := flag.CommandLine.Lookup(name)
+	if option == nil {
+		t.Fatalf("no option --%s", name)
+	}
+	changed := option.Changed
+	if slice, ok := option.Value.(flag.SliceValue); ok {
+		previous := slice.GetSlice()
+		t.Cleanup(func() { slice.Replace(previous) })
+	} else {
+		previous := option.Value.String()
+		t.Cleanup(func() { option.Value.Set(previous) })
+	}
+	t.Cleanup(func() { option.Changed = changed })
+
+	if err := flag.CommandLine.Set(name, value); err != nil {
+		t.Fatalf("--%s=%s: %s", name, value, err)
+	}
+}
+
+
+// writeFiles creates the files under dir, mapping their slash-separated names to contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+
+// mapTree has a test read from an in-memory tree of files, given as for writeFiles, returning
+// its root.
+func mapTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	fsys := make(fstest.MapFS)
+	for name, content := range files {
+		fsys["tree/"+name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	useFileSystem(t, NewIOFileSystem(fsys))
+	return "tree"
+}
+
+
+// symlink makes a symbolic link, skipping the test where they can't be made.
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip("can't make symlinks here: ", err)
+	}
+}
+
+
+// scan walks and hashes roots as a run would, returning the buckets of files that collided.
+func scan(t *testing.T, paths ...string) CollisionTable {
+	t.Helper()
+	roots = paths
+	visitedDirs = make(map[[2]uint64]string)
+	totalFiles, emptyFiles, underSizedFiles, hashingFiles, symlinkFiles, specialFiles, shortFiles = 0, 0, 0, 0, 0, 0, 0
+	excludedFiles, sampledOutFiles = 0, 0
+	t.Cleanup(func() { roots = nil })
+
+	hashReqCh, hashRepCh = make(chan *FileHash, 65536), make(chan *FileHash, *Threads*2)
+	go func() {
+		defer close(hashReqCh)
+		for _, root := range roots {
+			walkingRoot = root
+			fileSystem.Walk(root, walkFn)
+		}
+		flushShuffle()
+		flushRecent()
+	}()
+	go workers(hashReqCh, hashRepCh, selectedHasher())
+
+	return aggregateHashes(hashRepCh, nil)
+}
+
+
+// groupNames describes the buckets of a scan of dir as the sorted, space-separated names of the
+// files in each, relative to dir, in sorted order.
+func groupNames(dir string, collisions CollisionTable) []string {
+	groups := []string{}
+	for _, files := range collisions {
+		names := make([]string, len(files))
+		for i, file := range files {
+			relative, err := filepath.Rel(dir, file)
+			if err != nil {
+				relative = file
+			}
+			names[i] = filepath.ToSlash(relative)
+		}
+		sort.Strings(names)
+		groups = append(groups, strings.Join(names, " "))
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+
+// checkGroups fails a test if a scan of dir didn't find the groups wanted.
+func checkGroups(t *testing.T, dir string, collisions CollisionTable, want ...string) {
+	t.Helper()
+	if want == nil {
+		want = []string{}
+	}
+	if got := groupNames(dir, collisions); !reflect.DeepEqual(got, want) {
+		t.Errorf("groups %q, want %q", got, want)
+	}
+}
+
+
+// symlinkTree makes a tree with two copies of a file, a link to one of them, and a second link
+// with the same target as the first.
+func symlinkTree(t *testing.T) string {
+	setFlag(t, "min-bytes", "1")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"target.txt": "linked to", "copy.txt": "linked to"})
+	symlink(t, "target.txt", filepath.Join(dir, "link"))
+	symlink(t, "target.txt", filepath.Join(dir, "link2"))
+	return dir
+}
+
+
+func TestSymlinkModeSkip(t *testing.T) {
+	dir := symlinkTree(t)
+	checkGroups(t, dir, scan(t, dir), "copy.txt target.txt")
+	if symlinkFiles != 2 {
+		t.Errorf("%d symlinks skipped, want 2", symlinkFiles)
+	}
+}
+
+
+func TestSymlinkModeFollow(t *testing.T) {
+	setFlag(t, "symlink-mode", "follow")
+	dir := symlinkTree(t)
+	checkGroups(t, dir, scan(t, dir), "copy.txt link link2 target.txt")
+}
+
+
+func TestSymlinkModeFollowSkipsLinkedDirs(t *testing.T) {
+	setFlag(t, "symlink-mode", "follow")
+	setFlag(t, "min-bytes", "1")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"real/a.txt": "same", "real/b.txt": "same"})
+	symlink(t, "real", filepath.Join(dir, "alias"))
+	checkGroups(t, dir, scan(t, dir), "real/a.txt real/b.txt")
+	if symlinkFiles != 1 {
+		t.Errorf("%d symlinks skipped, want 1", symlinkFiles)
+	}
+}
+
+
+func TestSymlinkModeHashLink(t *testing.T) {
+	setFlag(t, "symlink-mode", "hash-link")
+	dir := symlinkTree(t)
+	checkGroups(t, dir, scan(t, dir), "copy.txt target.txt", "link link2")
+}
+
+
+func TestPanickingHasher(t *testing.T) {
+	panickedFiles = 0
+	hasher := func(request *FileHash) *FileHash {
+		if request.Pathname == "bad" {
+			panic("malformed")
+		}
+		request.Hash = request.Pathname
+		return request
+	}
+
+	requests, replies := make(chan *FileHash, 4), make(chan *FileHash, 4)
+	for _, name := range []string{"good", "bad", "bad", "better"} {
+		requests <- &FileHash{Pathname: name}
+	}
+	close(requests)
+	go workers(requests, replies, hasher)
+
+	// The workers carry on past the panics, so the replies channel still gets closed.
+	var hashed []string
+	for reply := range replies {
+		hashed = append(hashed, reply.Pathname)
+	}
+	sort.Strings(hashed)
+	if want := []string{"better", "good"}; !reflect.DeepEqual(hashed, want) {
+		t.Errorf("hashed %q, want %q", hashed, want)
+	}
+	if panickedFiles != 2 {
+		t.Errorf("%d files panicked, want 2", panickedFiles)
+	}
+}
+
+
+func TestEmptyAndUndersizedFiles(t *testing.T) {
+	setFlag(t, "min-bytes", "10")
+	dir := mapTree(t, map[string]string{
+		"empty1": "", "empty2": "",
+		"small1": "tiny", "small2": "tiny",
+		"large1": "large enough", "large2": "large enough",
+	})
+
+	checkGroups(t, dir, scan(t, dir), "large1 large2")
+	if emptyFiles != 2 || underSizedFiles != 2 {
+		t.Errorf("%d empty and %d undersized files, want 2 and 2", emptyFiles, underSizedFiles)
+	}
+
+	// --include-empty lets the empty files through, but not the small ones.
+	setFlag(t, "include-empty", "true")
+	checkGroups(t, dir, scan(t, dir), "empty1 empty2", "large1 large2")
+	if emptyFiles != 0 || underSizedFiles != 2 {
+		t.Errorf("%d empty and %d undersized files, want 0 and 2", emptyFiles, underSizedFiles)
+	}
+}
+
+
+func TestThoroughHash(t *testing.T) {
+	setFlag(t, "thorough", "true")
+	content := strings.Repeat("thorough ", 10000)
+	fileSystem := countFiles(NewIOFileSystem(fstest.MapFS{"file": {Data: []byte(content)}}))
+	useFileSystem(t, fileSystem)
+	path := "file"
+
+	first, err := fingerprint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileSystem.read != int64(len(content)) {
+		t.Errorf("read %d bytes of a %d byte file", fileSystem.read, len(content))
+	}
+
+	sha := sha512.Sum512([]byte(content))
+	md := md5.Sum([]byte(content))
+	if want := hex.EncodeToString(sha[:]) + "." + hex.EncodeToString(md[:]); first != want {
+		t.Errorf("hash %s, want %s", first, want)
+	}
+	if second, err := fingerprint(path); err != nil || second != first {
+		t.Errorf("hashed again to %s, want %s", second, first)
+	}
+}
+
+
+// benchmarkHash benchmarks fingerprinting a 1MiB file with a hashing option set, reporting how
+// many times over the file was read: once, for however many digests the option takes.
+func benchmarkHash(b *testing.B, name string) {
+	option := flag.CommandLine.Lookup(name)
+	option.Value.Set("true")
+	defer option.Value.Set("false")
+	counting, previous := countFiles(osFileSystem{}), fileSystem
+	fileSystem = counting
+	defer func() { fileSystem = previous }()
+
+	path := filepath.Join(b.TempDir(), "file")
+	content := bytes.Repeat([]byte("benchmark "), 1<<20/10)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(content)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fingerprint(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(counting.read)/float64(b.N)/float64(len(content)), "reads/op")
+}
+
+
+func BenchmarkThoroughHash(b *testing.B) {
+	benchmarkHash(b, "thorough")
+}
+
+
+func BenchmarkMultiAlgoHash(b *testing.B) {
+	benchmarkHash(b, "multi-algo")
+}
+
+
+func TestExtensionSensitive(t *testing.T) {
+	setFlag(t, "min-bytes", "1")
+	dir := mapTree(t, map[string]string{
+		"data.bin": "same bytes", "data.txt": "same bytes", "copy.TXT": "same bytes", "noext": "same bytes",
+	})
+
+	checkGroups(t, dir, scan(t, dir), "copy.TXT data.bin data.txt noext")
+
+	// Extensions are compared without regard to case.
+	setFlag(t, "extension-sensitive", "true")
+	checkGroups(t, dir, scan(t, dir), "copy.TXT data.txt")
+
+	// --quick keys files differently, but on the extension all the same.
+	setFlag(t, "quick", "true")
+	checkGroups(t, dir, scan(t, dir), "copy.TXT data.txt")
+}
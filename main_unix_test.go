@@ -0,0 +1,40 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+
+func TestNamedPipeIsSkipped(t *testing.T) {
+	setFlag(t, "min-bytes", "1")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "same", "b.txt": "same"})
+	if err := syscall.Mkfifo(filepath.Join(dir, "pipe"), 0644); err != nil {
+		t.Skip("can't make a named pipe here: ", err)
+	}
+
+	// Nothing writes to the pipe, so the scan would never finish if it were opened.
+	checkGroups(t, dir, scan(t, dir), "a.txt b.txt")
+	if specialFiles != 1 {
+		t.Errorf("%d special files, want 1", specialFiles)
+	}
+}
+
+
+func TestRootGivenTwice(t *testing.T) {
+	setFlag(t, "min-bytes", "1")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"tree/a": "same", "tree/b": "same", "tree/sub/c": "only one"})
+	tree := filepath.Join(dir, "tree")
+
+	// Directories are told apart by device and inode, so nothing matches itself whether the
+	// root is given twice as it is or again under a name which leads to the same directory.
+	checkGroups(t, dir, scan(t, tree, tree), "tree/a tree/b")
+	symlink(t, "tree", filepath.Join(dir, "alias"))
+	checkGroups(t, dir, scan(t, tree, filepath.Join(dir, "alias")+string(filepath.Separator)), "tree/a tree/b")
+}
@@ -0,0 +1,118 @@
+package main
+
+// Hashing inside archives, for --into-archives.
+//
+// Each zip and tar archive (optionally gzipped) is hashed as a file as usual, and then read a
+// second time for its members, which are hashed in the same way as files on disk and passed on
+// as files in their own right. A member is named by the path of its archive and its name within
+// it, joined by "::", so that "backups/2023.tar.gz::home/docs/cv.pdf" is the member
+// "home/docs/cv.pdf" of "backups/2023.tar.gz". Members group with each other across archives,
+// and with any file on disk that has the same content. Archives within archives aren't opened.
+
+import (
+	"hash"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+
+// memberSeparator joins the path of an archive to the name of a member in it.
+const memberSeparator = "::"
+
+
+// archiveMembers counts the members hashed, and memberArchives the archives they came from.
+var archiveMembers, memberArchives int64
+
+
+// isMember reports whether a path names a member of an archive rather than a file on disk.
+func isMember(path string) bool {
+	separator := strings.Index(path, memberSeparator)
+	return *IntoArchives && separator >= 0 && archiveKind(path[:separator]) != ""
+}
+
+
+// memberHasher wraps a hasher to hash the members of each archive it hashes, attaching them to
+// the reply for memberTap.
+func memberHasher(hasher Hasher) Hasher {
+	return func(request *FileHash) *FileHash {
+		kind := archiveKind(request.Pathname)
+		reply := hasher(request)
+		if reply == nil || kind == "" || reply.IsLink {
+			return reply
+		}
+
+		file, err := openFile(reply.Pathname)
+		if err != nil {
+			fileError("opening", reply.Pathname, err)
+			return reply
+		}
+		defer file.Close()
+
+		var members []*FileHash
+		err = readMembers(file, reply.Pathname, kind, func(name string, info os.FileInfo, content io.Reader) error {
+			// Members are held to the same limits as files on disk.
+			if info.Size() == 0 {
+				if !*IncludeEmpty {
+					return nil
+				}
+			} else if info.Size() < int64(*MinBytes) {
+				return nil
+			}
+			member := &FileHash{
+				Pathname: reply.Pathname + memberSeparator + name,
+				Size:     info.Size(),
+				ModTime:  info.ModTime(),
+				Mode:     info.Mode().Perm(),
+			}
+			hashString, err := digestString(member.Pathname, func(hashers ...hash.Hash) ([]string, error) {
+				return readerDigests(content, hashers...)
+			})
+			if err != nil {
+				return err
+			}
+			member.Hash = sizeKey(member.Size) + hashString + metadataKey(member)
+			members = append(members, member)
+			return nil
+		})
+		if err != nil {
+			// A damaged archive, or one that only looks like one by its name.
+			fileError("reading the members of", reply.Pathname, err)
+			return reply
+		}
+
+		atomic.AddInt64(&archiveMembers, int64(len(members)))
+		atomic.AddInt64(&memberArchives, 1)
+		reply.Members = members
+		return reply
+	}
+}
+
+
+// memberTap forwards every reply on to the returned channel, followed by the members of the
+// archive it is for, if any.
+func memberTap(replies <-chan *FileHash) <-chan *FileHash {
+	tapped := make(chan *FileHash, cap(replies))
+
+	go func() {
+		defer close(tapped)
+		for reply := range replies {
+			members := reply.Members
+			reply.Members = nil
+			tapped <- reply
+			for _, member := range members {
+				tapped <- member
+			}
+		}
+	}()
+
+	return tapped
+}
+
+
+// logMembers logs how many archive members were hashed.
+func logMembers() {
+	log.Print("Archive members: ", archiveMembers, " hashed from ", memberArchives, " archives")
+}
@@ -0,0 +1,66 @@
+package main
+
+// Comparing file names across platforms. macOS stores names decomposed (NFD) while most other
+// systems store them composed (NFC), so the same "café.jpg" copied from each won't compare
+// equal byte for byte; --normalize-unicode puts every name into NFC before it is compared.
+//
+// On case-insensitive filesystems (the default on macOS and Windows) "File.txt" and
+// "file.txt" are the same file, which roots given in different cases would otherwise walk
+// twice and report as duplicates of themselves; --case-insensitive-fs skips the second sight.
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+
+// baseName is the last element of a path as it should be compared by the name-based modes.
+func baseName(path string) string {
+	name := filepath.Base(path)
+	if *NormalizeUnicode {
+		name = norm.NFC.String(name)
+	}
+	return name
+}
+
+
+// walkedPaths are the folded absolute paths of the files walked so far, for
+// --case-insensitive-fs; only the walk uses it.
+var walkedPaths = make(map[string]bool)
+
+// aliasedFiles counts files skipped for having been walked already under another case.
+var aliasedFiles int64
+
+
+// foldedPath is the form of an absolute path which is the same however its name was cased or
+// composed.
+func foldedPath(path string) string {
+	return strings.ToLower(norm.NFC.String(absolutePath(path)))
+}
+
+
+// isAliased reports whether a file has already been walked under a path that differs only in
+// case, remembering it if not.
+func isAliased(path string) bool {
+	if !*CaseInsensitiveFS {
+		return false
+	}
+	folded := foldedPath(path)
+	if walkedPaths[folded] {
+		aliasedFiles++
+		return true
+	}
+	walkedPaths[folded] = true
+	return false
+}
+
+
+// reportAliases logs how many files were skipped by isAliased.
+func reportAliases() {
+	if aliasedFiles > 0 {
+		log.Print("Aliased: ", aliasedFiles, " files already seen under a path differing only in case or composition were skipped.")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+
+// The two ways of writing "café.jpg": with a precomposed é, and with an e and a combining accent.
+const (
+	composedName   = "caf\u00e9.jpg"
+	decomposedName = "cafe\u0301.jpg"
+)
+
+
+func TestBaseNameNormalizeUnicode(t *testing.T) {
+	if baseName("nfd/"+decomposedName) == baseName("nfc/"+composedName) {
+		t.Error("the names matched without --normalize-unicode")
+	}
+	setFlag(t, "normalize-unicode", "true")
+	if got := baseName("nfd/" + decomposedName); got != composedName {
+		t.Errorf("baseName gave %q, want %q", got, composedName)
+	}
+	if got := baseName("nfc/" + composedName); got != composedName {
+		t.Errorf("baseName gave %q, want %q", got, composedName)
+	}
+}
+
+
+func TestTrustNameSizeNormalizeUnicode(t *testing.T) {
+	setFlag(t, "min-bytes", "1")
+	setFlag(t, "trust-name-size", "true")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"nfc/" + composedName: "from linux", "nfd/" + decomposedName: "from a mac"})
+
+	checkGroups(t, dir, scan(t, dir))
+
+	setFlag(t, "normalize-unicode", "true")
+	checkGroups(t, dir, scan(t, dir), "nfc/"+composedName+" nfd/"+decomposedName)
+}
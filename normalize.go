@@ -0,0 +1,131 @@
+package main
+
+// Text normalization for --text-normalize.
+//
+// Text files have their line endings converted to "\n", trailing spaces and tabs stripped from
+// each line, and any trailing blank lines removed, before they are hashed. This happens as the
+// file is read, so it costs no more memory than hashing the raw bytes would.
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+
+// textSniffBytes is how much of a file is checked for NUL bytes to decide if it is text.
+const textSniffBytes = 8192
+
+
+// textNormalizer is a reader which normalizes the text read from src.
+type textNormalizer struct {
+	src []byte
+	in  io.Reader
+	out []byte
+	pos int
+	// Whitespace and newlines which are only written if something other than whitespace
+	// follows them.
+	spaces   []byte
+	newlines int
+	lastCR   bool
+	eof      bool
+}
+
+
+// newTextNormalizer wraps a reader with a textNormalizer.
+func newTextNormalizer(reader io.Reader) *textNormalizer {
+	return &textNormalizer{src: make([]byte, 32*1024), in: reader}
+}
+
+
+// Read implements io.Reader.
+func (t *textNormalizer) Read(p []byte) (int, error) {
+	for t.pos == len(t.out) {
+		if t.eof {
+			return 0, io.EOF
+		}
+		t.out, t.pos = t.out[:0], 0
+		n, err := t.in.Read(t.src)
+		t.process(t.src[:n])
+		if err == io.EOF {
+			t.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, t.out[t.pos:])
+	t.pos += n
+	return n, nil
+}
+
+
+// process normalizes a chunk of input onto the output buffer.
+func (t *textNormalizer) process(chunk []byte) {
+	for _, c := range chunk {
+		switch c {
+		case '\n':
+			if t.lastCR {
+				// The second half of a "\r\n".
+				t.lastCR = false
+				continue
+			}
+			t.spaces, t.newlines = t.spaces[:0], t.newlines+1
+		case '\r':
+			t.lastCR = true
+			t.spaces, t.newlines = t.spaces[:0], t.newlines+1
+			continue
+		case ' ', '\t':
+			t.spaces = append(t.spaces, c)
+		default:
+			for ; t.newlines > 0; t.newlines-- {
+				t.out = append(t.out, '\n')
+			}
+			t.out = append(t.out, t.spaces...)
+			t.out = append(t.out, c)
+			t.spaces = t.spaces[:0]
+		}
+		t.lastCR = false
+	}
+}
+
+
+// isTextCandidate reports whether a file should be normalized based on its name; if
+// --text-ext wasn't given, every file is a candidate and the content decides.
+func isTextCandidate(pathname string) bool {
+	if len(*TextExtensions) == 0 {
+		return true
+	}
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(pathname)), ".")
+	for _, candidate := range *TextExtensions {
+		if strings.TrimPrefix(strings.ToLower(candidate), ".") == ext {
+			return true
+		}
+	}
+	return false
+}
+
+
+// normalizeText wraps reader in a textNormalizer if the content looks like text, which is to
+// say that there are no NUL bytes near the start of it.
+func normalizeText(reader io.Reader) io.Reader {
+	buffered := bufio.NewReaderSize(reader, textSniffBytes)
+	head, _ := buffered.Peek(textSniffBytes)
+	if bytes.IndexByte(head, 0) >= 0 {
+		return buffered
+	}
+	return newTextNormalizer(buffered)
+}
+
+
+// openContent opens a file with openRange and, with --text-normalize, normalizes it if it is
+// text.
+func openContent(pathname string) (File, io.Reader, error) {
+	file, reader, err := openRange(pathname)
+	if err != nil || !*TextNormalize || !isTextCandidate(pathname) {
+		return file, reader, err
+	}
+	return file, normalizeText(reader), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+// Limiting the number of files open at once with --max-open-files.
+
+import (
+	"sync"
+)
+
+
+// openFileSlots is a semaphore with one slot per file that may be open at once, or nil if
+// there is no limit.
+var openFileSlots chan struct{}
+
+
+// limitOpenFiles sets up the semaphore for --max-open-files, defaulting to half of the open
+// file limit for the process where that can be determined.
+func limitOpenFiles() {
+	limit := *MaxOpenFiles
+	if limit == 0 {
+		limit = openFileLimit() / 2
+	}
+	if limit <= 0 {
+		return
+	}
+	openFileSlots = make(chan struct{}, limit)
+}
+
+
+// acquireOpenFile waits for a slot to open a file in.
+func acquireOpenFile() {
+	if openFileSlots != nil {
+		openFileSlots <- struct{}{}
+	}
+}
+
+
+// releaseOpenFile gives up a slot taken by acquireOpenFile.
+func releaseOpenFile() {
+	if openFileSlots != nil {
+		<-openFileSlots
+	}
+}
+
+
+// slottedFile is a File which gives up its slot when it is closed.
+type slottedFile struct {
+	File
+	release sync.Once
+}
+
+
+// Close closes the file and releases its slot, once.
+func (f *slottedFile) Close() error {
+	err := f.File.Close()
+	f.release.Do(releaseOpenFile)
+	return err
+}
+
+
+// openFile opens a file for reading once a slot is available for it.
+func openFile(pathname string) (File, error) {
+	acquireOpenFile()
+	file, err := fileSystem.Open(pathname)
+	if err != nil {
+		releaseOpenFile()
+		return nil, err
+	}
+	return &slottedFile{File: file}, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+
+// countingFileSystem wraps a filesystem to keep track of which files are opened, the most open
+// at once, and how much is read from them.
+type countingFileSystem struct {
+	FileSystem
+	lock       sync.Mutex
+	opened     map[string]int
+	open, peak int
+	read       int64
+}
+
+
+// countFiles wraps fsys in a countingFileSystem.
+func countFiles(fsys FileSystem) *countingFileSystem {
+	return &countingFileSystem{FileSystem: fsys, opened: make(map[string]int)}
+}
+
+func (f *countingFileSystem) Open(name string) (File, error) {
+	file, err := f.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.opened[name]++
+	if f.open++; f.open > f.peak {
+		f.peak = f.open
+	}
+	return &countedFile{File: file, fileSystem: f}, nil
+}
+
+
+// countedFile is a file opened by a countingFileSystem.
+type countedFile struct {
+	File
+	fileSystem *countingFileSystem
+}
+
+func (f *countedFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	atomic.AddInt64(&f.fileSystem.read, int64(n))
+	return n, err
+}
+
+func (f *countedFile) Close() error {
+	f.fileSystem.lock.Lock()
+	f.fileSystem.open--
+	f.fileSystem.lock.Unlock()
+	return f.File.Close()
+}
+
+
+func TestMaxOpenFiles(t *testing.T) {
+	setFlag(t, "min-bytes", "1")
+	setFlag(t, "threads", "16")
+	setFlag(t, "max-open-files", "2")
+	limitOpenFiles()
+	defer func() { openFileSlots = nil }()
+
+	fileSystem := countFiles(osFileSystem{})
+	useFileSystem(t, fileSystem)
+
+	dir := t.TempDir()
+	files := make(map[string]string)
+	var want []string
+	for i := 0; i < 100; i++ {
+		files[fmt.Sprintf("a%03d", i)] = fmt.Sprint("content ", i)
+		files[fmt.Sprintf("b%03d", i)] = fmt.Sprint("content ", i)
+		want = append(want, fmt.Sprintf("a%03d b%03d", i, i))
+	}
+	writeFiles(t, dir, files)
+
+	// Every file is still hashed, just never more than two at a time.
+	checkGroups(t, dir, scan(t, dir), want...)
+	if fileSystem.peak == 0 || fileSystem.peak > 2 {
+		t.Errorf("%d files were open at once, want at most 2", fileSystem.peak)
+	}
+	if fileSystem.open != 0 {
+		t.Errorf("%d files were left open", fileSystem.open)
+	}
+}
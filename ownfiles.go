@@ -0,0 +1,77 @@
+package main
+
+// Keeping findupe's own output out of the walk. A cache, manifest, plan or listing written
+// into the tree being scanned would otherwise be hashed while it is still being written, or
+// reported as a duplicate of last run's copy.
+
+import (
+	"os"
+	"path/filepath"
+)
+
+
+// ownPaths are the absolute paths of the files this run writes, and spillDir the absolute path
+// of the directory --spill writes its runs into.
+var ownPaths = make(map[string]bool)
+var spillDir string
+
+// stdoutInfo describes standard output when it has been redirected to a file.
+var stdoutInfo os.FileInfo
+
+// workingDir is the directory relative paths are resolved against.
+var workingDir string
+
+
+// absolutePath resolves a path against the working directory without asking the OS for it
+// every time.
+func absolutePath(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(workingDir, path)
+}
+
+
+// noteOwnFiles records the files and directories the options say will be written to.
+func noteOwnFiles() {
+	workingDir, _ = os.Getwd()
+
+	for _, path := range []string{*Cache, *ErrorsOutput, *PlanOutput, *LiveManifest, *SizeIndexCache} {
+		if path != "" {
+			ownPaths[absolutePath(path)] = true
+		}
+	}
+	// saveCache and saveListings write through temporary files.
+	if *Cache != "" {
+		ownPaths[absolutePath(*Cache+".tmp")] = true
+	}
+	if *SizeIndexCache != "" {
+		ownPaths[absolutePath(*SizeIndexCache+".tmp")] = true
+	}
+	if *Spill > 0 {
+		spillDir = os.TempDir()
+		if *SpillDir != "" {
+			spillDir = *SpillDir
+		}
+		spillDir = absolutePath(spillDir)
+	}
+
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode().IsRegular() {
+		stdoutInfo = info
+	}
+}
+
+
+// isOwnFile reports whether a walked path is one this run is writing to.
+func isOwnFile(path string, info os.FileInfo) bool {
+	absolute := absolutePath(path)
+	if ownPaths[absolute] {
+		return true
+	}
+	if spillDir != "" && filepath.Dir(absolute) == spillDir {
+		if matched, _ := filepath.Match(spillPattern, filepath.Base(absolute)); matched {
+			return true
+		}
+	}
+	return stdoutInfo != nil && info != nil && info.Mode().IsRegular() && os.SameFile(info, stdoutInfo)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+
+func TestOwnFilesAreNotScanned(t *testing.T) {
+	setFlag(t, "min-bytes", "1")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"results/cache.jsonl":     "last run's cache",
+		"results/cache.jsonl.bak": "last run's cache",
+		"results/plan.json":       "last run's plan",
+		"results/plan.json.bak":   "last run's plan",
+		"photos/a.jpg":            "a photo",
+		"photos/b.jpg":            "a photo",
+	})
+
+	setFlag(t, "cache", filepath.Join(dir, "results", "cache.jsonl"))
+	setFlag(t, "plan-output", filepath.Join(dir, "results", "plan.json"))
+	noteOwnFiles()
+	defer func() { ownPaths, stdoutInfo = make(map[string]bool), nil }()
+
+	checkGroups(t, dir, scan(t, dir), "photos/a.jpg photos/b.jpg")
+	if excludedFiles != 2 {
+		t.Errorf("%d files excluded, want 2", excludedFiles)
+	}
+}
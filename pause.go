@@ -0,0 +1,53 @@
+package main
+
+// Pausing and resuming the scan at runtime, so that a long background run can give the disks
+// back for a while without being killed. The signals which do it are platform specific; see
+// watchPauseSignals.
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+
+// paused is non-zero while the scan is paused; pauseLock and pauseCond guard changes to it
+// and wake whoever is waiting for it to clear.
+var paused int32
+var pauseLock sync.Mutex
+var pauseCond = sync.NewCond(&pauseLock)
+
+
+// setPaused pauses or resumes the walker and the workers.
+func setPaused(pause bool) {
+	pauseLock.Lock()
+	defer pauseLock.Unlock()
+
+	state := int32(0)
+	if pause {
+		state = 1
+	}
+	if atomic.SwapInt32(&paused, state) == state {
+		return
+	}
+	if pause {
+		log.Print("Paused, with ", atomic.LoadInt64(&completedFiles), " files hashed")
+	} else {
+		log.Print("Resumed")
+		pauseCond.Broadcast()
+	}
+}
+
+
+// waitIfPaused blocks for as long as the scan is paused. Files already being read are
+// finished first, as this is only checked between them.
+func waitIfPaused() {
+	if atomic.LoadInt32(&paused) == 0 {
+		return
+	}
+	pauseLock.Lock()
+	for atomic.LoadInt32(&paused) != 0 {
+		pauseCond.Wait()
+	}
+	pauseLock.Unlock()
+}
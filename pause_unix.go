@@ -0,0 +1,25 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+
+// watchPauseSignals pauses the scan on SIGUSR1 and resumes it on SIGUSR2 or SIGCONT, the
+// latter so that a scan which was paused and then stopped and continued from the shell
+// carries on by itself.
+func watchPauseSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGCONT)
+
+	go func() {
+		for sig := range signals {
+			setPaused(sig == syscall.SIGUSR1)
+		}
+	}()
+}
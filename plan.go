@@ -0,0 +1,193 @@
+package main
+
+// Deletion plans, for --plan-output and --apply-plan.
+//
+// --plan-output writes what the selected action (--delete if none is given) would do to each
+// group as JSON instead of doing it: the file kept, the files acted on, and the bytes that
+// would be saved. --apply-plan reads such a plan back, perhaps much later or elsewhere, and
+// carries it out, but only on files which still have the size and hash they were planned
+// with, so nothing is deleted that has become the only copy of something. Under a heuristic,
+// whose hash can miss a change, the candidates are compared with the file kept as well.
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+
+// Plan is what an action would do to every group of duplicates.
+type Plan struct {
+	Version    int         `json:"findupe-plan"`
+	Settings   string      `json:"settings"`
+	Action     string      `json:"action"`
+	MoveTo     string      `json:"move_to,omitempty"`
+	Roots      []string    `json:"roots"`
+	BytesSaved int64       `json:"bytes_saved"`
+	Groups     []PlanGroup `json:"groups"`
+}
+
+
+// PlanGroup is what an action would do to one group.
+type PlanGroup struct {
+	ID         string   `json:"group_id,omitempty"`
+	Hash       string   `json:"hash"`
+	Size       int64    `json:"size"`
+	Keep       string   `json:"keep"`
+	Candidates []string `json:"candidates"`
+	BytesSaved int64    `json:"bytes_saved"`
+}
+
+
+// planSettings describes the options which affect the hashes in a plan, which have to match
+// for the plan's files to be checked when it is applied.
+func planSettings() string {
+	return cacheSettings() + fmt.Sprintf(" include-mode=%v include-owner=%v symlink-mode=%s",
+		*IncludeMode, *IncludeOwner, *SymlinkMode)
+}
+
+
+// writePlan writes the plan for action, or for --delete if there isn't one, to filename.
+func writePlan(filename, action string, groups []Group) error {
+	if action == "" {
+		action = "delete"
+	}
+
+	plan := Plan{Version: 1, Settings: planSettings(), Action: action, MoveTo: *MoveTo, Roots: roots}
+	for _, group := range groups {
+		planned := PlanGroup{
+			ID:         group.ID,
+			Hash:       unsplitHash(group.Hash),
+			Size:       group.Size,
+			Keep:       group.Files[0],
+			Candidates: group.Files[1:],
+			BytesSaved: group.WastedBytes(),
+		}
+		plan.Groups = append(plan.Groups, planned)
+		plan.BytesSaved += planned.BytesSaved
+	}
+
+	encoded, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, append(encoded, '\n'), 0644); err != nil {
+		return err
+	}
+
+	var files int64
+	for _, planned := range plan.Groups {
+		files += int64(len(planned.Candidates))
+	}
+	log.Print("Plan: ", actionWords[action][0], " ", files, " files in ", len(plan.Groups), " groups, saving ",
+		formatBytes(plan.BytesSaved), ", written to ", filename)
+	return nil
+}
+
+
+// isUnchanged reports whether a planned file still has the size and hash it was planned with.
+func isUnchanged(pathname, hash string, size int64) bool {
+	info, err := fileSystem.Lstat(pathname)
+	if err != nil {
+		fileError("checking", pathname, err)
+		return false
+	}
+
+	request := &FileHash{Pathname: pathname, Size: info.Size(), ModTime: info.ModTime(), Mode: info.Mode().Perm()}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if *SymlinkMode != "hash-link" {
+			if info, err = fileSystem.Stat(pathname); err != nil {
+				fileError("checking", pathname, err)
+				return false
+			}
+			request.Size, request.ModTime, request.Mode = info.Size(), info.ModTime(), info.Mode().Perm()
+		} else {
+			request.IsLink = true
+		}
+	}
+	if *IncludeOwner {
+		request.Owner = fileOwner(info)
+	}
+
+	// With --compare-contents-only the files of a group needn't be the same size anyway.
+	if !request.IsLink && !*ContentsOnly && request.Size != size {
+		log.Printf("%s has changed size since the plan was made, leaving it alone", pathname)
+		return false
+	}
+	reply := selectedHasher()(request)
+	if reply == nil {
+		return false
+	}
+	if reply.Hash != hash {
+		log.Printf("%s has changed since the plan was made, leaving it alone", pathname)
+		return false
+	}
+	return true
+}
+
+
+// isStillSame reports whether a candidate still has the same content as the file being kept.
+// The hash of a heuristic such as --quick or --trust-name-size wouldn't notice a file edited
+// in place since the plan was made, so under one the files are compared byte by byte.
+func isStillSame(keeper, candidate string) bool {
+	if heuristicMode() == "" {
+		return true
+	}
+	same, err := sameContent(keeper, candidate)
+	if err != nil {
+		fileError("comparing", candidate, err)
+		return false
+	}
+	if !same {
+		log.Printf("%s no longer matches %s, leaving it alone", candidate, keeper)
+	}
+	return same
+}
+
+
+// applyPlan carries out the plan in filename on the files which haven't changed since.
+func applyPlan(filename string) error {
+	encoded, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(encoded, &plan); err != nil || plan.Version != 1 {
+		return fmt.Errorf("%s is not a findupe plan", filename)
+	}
+	if _, ok := actionWords[plan.Action]; !ok {
+		return fmt.Errorf("%s has an unknown action %q", filename, plan.Action)
+	}
+	if plan.Action == "move" && plan.MoveTo == "" {
+		return fmt.Errorf("%s is a move plan without a destination", filename)
+	}
+	if plan.Settings != planSettings() {
+		return fmt.Errorf("%s was made with different options (%s), which have to be given again to check its files", filename, plan.Settings)
+	}
+
+	// Moves keep the path relative to the roots the plan was made with.
+	*MoveTo = plan.MoveTo
+	roots = plan.Roots
+
+	// Only act on the files which have the same content as what's being kept.
+	var groups []Group
+	for _, planned := range plan.Groups {
+		if !isUnchanged(planned.Keep, planned.Hash, planned.Size) {
+			continue
+		}
+		group := Group{Hash: planned.Hash, Size: planned.Size, Files: []string{planned.Keep}}
+		for _, candidate := range planned.Candidates {
+			if isUnchanged(candidate, planned.Hash, planned.Size) && isStillSame(planned.Keep, candidate) {
+				group.Files = append(group.Files, candidate)
+			}
+		}
+		if group.Count() > 1 {
+			groups = append(groups, group)
+		}
+	}
+
+	performAction(plan.Action, groups)
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+
+func TestApplyPlanAfterEdit(t *testing.T) {
+	setFlag(t, "min-bytes", "1")
+	setFlag(t, "trust-name-size", "true")
+	setFlag(t, "verify", "true")
+	setFlag(t, "yes", "true")
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a/notes.txt": "original", "b/notes.txt": "original", "c/notes.txt": "original"})
+
+	collisions := scan(t, dir)
+	verifyCollisions(collisions)
+	checkGroups(t, dir, collisions, "a/notes.txt b/notes.txt c/notes.txt")
+	plan := filepath.Join(t.TempDir(), "plan.json")
+	if err := writePlan(plan, "delete", groupsOf(collisions)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same name and size, so --trust-name-size would still take it for a copy.
+	edited := filepath.Join(dir, "b", "notes.txt")
+	if err := os.WriteFile(edited, []byte("rewrites"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyPlan(plan); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, err := os.ReadFile(edited); err != nil || string(content) != "rewrites" {
+		t.Errorf("the edited candidate was acted on")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c", "notes.txt")); !os.IsNotExist(err) {
+		t.Errorf("the unchanged candidate wasn't deleted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a", "notes.txt")); err != nil {
+		t.Errorf("the kept file has gone: %s", err)
+	}
+}
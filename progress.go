@@ -0,0 +1,165 @@
+package main
+
+// Progress reporting with --progress-bar.
+//
+// When stderr is a terminal a bar is redrawn in place a few times a second, and any log lines
+// are written above it rather than through it; otherwise a plain progress line is logged every
+// few seconds instead.
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+
+// Progress counters, which are updated by the walker and the workers as they go, so use atomic
+// operations.
+var dispatchedBytes, completedFiles, completedBytes int64
+
+// walkDone is closed once the walk has dispatched everything.
+var walkDone = make(chan struct{})
+
+
+// progressBar draws the bar, and keeps log output from scribbling over it.
+type progressBar struct {
+	lock     sync.Mutex
+	out      io.Writer
+	terminal bool
+	started  time.Time
+	line     string
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+
+// isTerminal reports whether a file is a character device, which is close enough to a
+// terminal for our purposes.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+
+// startProgress starts reporting progress in the background, returning the bar so that it can
+// be stopped.
+func startProgress() *progressBar {
+	bar := &progressBar{
+		out:      os.Stderr,
+		terminal: isTerminal(os.Stderr),
+		started:  time.Now(),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if bar.terminal {
+		log.SetOutput(bar)
+	}
+
+	go bar.run()
+
+	return bar
+}
+
+
+// Write lets the bar be used for log output, clearing the bar for the log line and then
+// drawing it again below it.
+func (b *progressBar) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	fmt.Fprint(b.out, "\r\x1b[K")
+	n, err := b.out.Write(p)
+	fmt.Fprint(b.out, b.line)
+	return n, err
+}
+
+
+// run redraws the bar until stopped.
+func (b *progressBar) run() {
+	defer close(b.stopped)
+
+	interval := 5 * time.Second
+	if b.terminal {
+		interval = 200 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			b.draw()
+			if b.terminal {
+				b.lock.Lock()
+				fmt.Fprintln(b.out)
+				b.line = ""
+				b.lock.Unlock()
+				log.SetOutput(os.Stderr)
+			}
+			return
+		case <-ticker.C:
+			b.draw()
+		}
+	}
+}
+
+
+// draw renders the current state of the counters.
+func (b *progressBar) draw() {
+	files, bytes := atomic.LoadInt64(&completedFiles), atomic.LoadInt64(&completedBytes)
+	totalFiles, totalBytes := atomic.LoadInt64(&hashingFiles), atomic.LoadInt64(&dispatchedBytes)
+	elapsed := time.Since(b.started).Seconds()
+	rate := float64(bytes) / elapsed
+
+	walking := true
+	select {
+	case <-walkDone:
+		walking = false
+	default:
+	}
+
+	eta := "walking"
+	fraction := 0.0
+	if totalBytes > 0 {
+		fraction = float64(bytes) / float64(totalBytes)
+	}
+	if fraction > 1 {
+		// The passes of --fast can read the same file more than once.
+		fraction = 1
+	}
+	if !walking {
+		eta = "ETA ?"
+		if rate > 0 {
+			eta = "ETA " + (time.Duration(float64(totalBytes-bytes)/rate) * time.Second).Round(time.Second).String()
+		}
+	}
+
+	status := fmt.Sprintf("%s/%s files %3.0f%% %s/s %s", formatCount(files), formatCount(totalFiles),
+		fraction*100, formatBytes(int64(rate)), eta)
+
+	if !b.terminal {
+		log.Print("Progress: ", status)
+		return
+	}
+
+	const width = 30
+	filled := int(fraction * width)
+	bar := "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "] "
+
+	b.lock.Lock()
+	b.line = bar + status
+	fmt.Fprint(b.out, "\r\x1b[K", b.line)
+	b.lock.Unlock()
+}
+
+
+// finish stops the bar after drawing it one last time.
+func (b *progressBar) finish() {
+	close(b.stop)
+	<-b.stopped
+}
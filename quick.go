@@ -0,0 +1,55 @@
+package main
+
+// The --quick mode, which buckets files on their size and a CRC32 of their first QuickBytes
+// without reading the rest of the file. The results are only "likely" duplicates.
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+
+// QuickBytes is how much of the start of each file --quick reads.
+const QuickBytes = 64 * 1024
+
+
+// quickRequest keys a request on its size and the CRC32 of its first QuickBytes.
+func quickRequest(request *FileHash) *FileHash {
+	// Cached hashes and link targets are already as cheap as it gets.
+	if request.CachedHash != "" || request.IsLink {
+		return hashRequest(request)
+	}
+
+	pathname := strings.ReplaceAll(request.Pathname, "\\", "/")
+	hashString, err := withRetries(func() (string, error) {
+		return hashQuick(pathname)
+	})
+	if err != nil {
+		readError(pathname, err)
+		return nil
+	}
+
+	request.Pathname = pathname
+	request.Hash = sizeKey(request.Size) + hashString + metadataKey(request)
+
+	return request
+}
+
+
+// hashQuick hashes the first QuickBytes of a file.
+func hashQuick(pathname string) (string, error) {
+	file, reader, err := openContent(pathname)
+	if err != nil {
+		return "", err
+	}
+
+	defer file.Close()
+
+	hasher := crc32.NewIEEE()
+	if _, err = io.CopyN(hasher, reader, QuickBytes); err != nil && err != io.EOF {
+		return "", err
+	}
+	return fmt.Sprintf("crc32.%08x", hasher.Sum32()), nil
+}
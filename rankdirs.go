@@ -0,0 +1,69 @@
+package main
+
+// The reclaimable space ranking, for --rank-dirs.
+//
+// Each duplicate (every file of a group but the one that would be kept) has its size charged
+// to the top-level directory it is in, under its root, so that the directories whose clean-up
+// would free the most come first.
+
+import (
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+
+// topLevelDir is the first directory of a path under its root, or "." for files directly in
+// the root. With more than one root it is given with the root in front of it.
+func topLevelDir(path string) string {
+	dir := "."
+	if relative := relativePath(path); strings.Contains(relative, "/") {
+		dir = relative[:strings.IndexByte(relative, '/')]
+	}
+	if len(roots) > 1 {
+		if root := rootOf(path); root != "" {
+			dir = filepath.Join(root, dir)
+		}
+	}
+	return dir
+}
+
+
+// logDirRanking logs the reclaimable bytes and duplicate counts of every top-level directory
+// holding duplicates, most reclaimable first.
+func logDirRanking(collisions CollisionTable) {
+	type rank struct {
+		dir   string
+		bytes int64
+		files int64
+	}
+
+	ranks := make(map[string]*rank)
+	for _, group := range sortedGroups(collisions) {
+		for _, file := range group.Files[1:] {
+			dir := topLevelDir(file)
+			if ranks[dir] == nil {
+				ranks[dir] = &rank{dir: dir}
+			}
+			ranks[dir].bytes += group.Size
+			ranks[dir].files++
+		}
+	}
+
+	ranking := make([]*rank, 0, len(ranks))
+	for _, r := range ranks {
+		ranking = append(ranking, r)
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		if ranking[i].bytes != ranking[j].bytes {
+			return ranking[i].bytes > ranking[j].bytes
+		}
+		return ranking[i].dir < ranking[j].dir
+	})
+
+	log.Print("Reclaimable by directory:")
+	for _, r := range ranking {
+		log.Printf("  %10s %8s files  %s", formatBytes(r.bytes), formatCount(r.files), r.dir)
+	}
+}
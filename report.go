@@ -0,0 +1,563 @@
+package main
+
+// Output of listings in the various --formats.
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+
+// groupSeparator and fileSeparator are --group-separator and --file-separator with any
+// backslash escapes interpreted.
+var groupSeparator, fileSeparator string
+
+
+// parseSeparators interprets the escapes in --group-separator and --file-separator, which
+// --single-line overrides with a space between files and nothing between groups.
+func parseSeparators() error {
+	if *SingleLine {
+		groupSeparator, fileSeparator = "", " "
+		return nil
+	}
+
+	var err error
+	if groupSeparator, err = unescape(*GroupSeparator); err != nil {
+		return fmt.Errorf("--group-separator: %w", err)
+	}
+	if fileSeparator, err = unescape(*FileSeparator); err != nil {
+		return fmt.Errorf("--file-separator: %w", err)
+	}
+	return nil
+}
+
+
+// unescape interprets Go-style backslash escapes such as \n and \t in a string.
+func unescape(text string) (string, error) {
+	return strconv.Unquote(`"` + strings.ReplaceAll(text, `"`, `\"`) + `"`)
+}
+
+
+// reportTemplate is the parsed --template, if there was one.
+var reportTemplate *template.Template
+
+
+// parseTemplate parses --template, with the join and quote functions available, so that
+// mistakes are caught before the scan starts.
+func parseTemplate() error {
+	if *Template == "" {
+		return nil
+	}
+	text := *Template
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	funcs := template.FuncMap{"join": strings.Join, "quote": strconv.Quote}
+	parsed, err := template.New("report").Funcs(funcs).Parse(text)
+	if err != nil {
+		return err
+	}
+	reportTemplate = parsed
+	return nil
+}
+
+
+// Group is the serializable form of a collision bucket.
+type Group struct {
+	ID    string   `json:"group_id,omitempty" yaml:"group_id,omitempty"`
+	Hash  string   `json:"hash" yaml:"hash"`
+	Size  int64    `json:"size" yaml:"size"`
+	Files []string `json:"files" yaml:"files"`
+	Notes []string `json:"notes,omitempty" yaml:"notes,omitempty"`
+}
+
+
+// Count is the number of files in the group.
+func (g Group) Count() int {
+	return len(g.Files)
+}
+
+
+// WastedBytes is how much space is taken up by all but one of the files in the group.
+func (g Group) WastedBytes() int64 {
+	return int64(len(g.Files)-1) * g.Size
+}
+
+
+// HashRecord is the serializable form of a single file's hash.
+type HashRecord struct {
+	Path    string    `json:"path" yaml:"path"`
+	Size    int64     `json:"size" yaml:"size"`
+	ModTime time.Time `json:"mtime" yaml:"mtime"`
+	Hash    string    `json:"hash" yaml:"hash"`
+}
+
+
+// fileSorts are the valid values for --file-sort.
+var fileSorts = []string{"", "path", "mtime", "size"}
+
+
+// sortFiles orders the files of a group by --file-sort, oldest or smallest first, leaving them
+// in the order they were found if it isn't given.
+func sortFiles(files []string) []string {
+	if *FileSort == "" || *FileSort == "path" {
+		if *FileSort == "path" {
+			sort.Strings(files)
+		}
+		return files
+	}
+
+	// Look each file up once rather than on every comparison.
+	infos := make(map[string]os.FileInfo, len(files))
+	for _, file := range files {
+		if info, err := fileSystem.Stat(file); err == nil {
+			infos[file] = info
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		first, second := infos[files[i]], infos[files[j]]
+		switch {
+		case first == nil || second == nil:
+			// Anything we can stat goes before something we can't.
+			return second == nil && first != nil
+		case *FileSort == "size" && first.Size() != second.Size():
+			return first.Size() < second.Size()
+		case *FileSort == "mtime" && !first.ModTime().Equal(second.ModTime()):
+			return first.ModTime().Before(second.ModTime())
+		}
+		return files[i] < files[j]
+	})
+	return files
+}
+
+
+// newGroup creates the Group for a bucket, with the file to keep listed first.
+func newGroup(hash string, files []string) Group {
+	group := Group{Hash: hash, Size: hashSize(hash), Files: keeperFirst(sortFiles(files))}
+	if *ContentsOnly || isArchiveHash(hash) {
+		// The key has no size, and the files may not all be the same size anyway, so go by
+		// the size of the one being kept.
+		group.Size = 0
+		if info, err := fileSystem.Stat(group.Files[0]); err == nil {
+			group.Size = info.Size()
+		}
+	}
+	annotate(&group)
+	return group
+}
+
+
+// groupID derives a short id for a group from its hash, for --group-ids, so that it is the
+// same on every run that finds the group with the same options.
+func groupID(hash string) string {
+	sum := sha256.Sum256([]byte(hash))
+	// Ten bytes make sixteen base32 digits, with no padding.
+	return strings.ToLower(base32.StdEncoding.EncodeToString(sum[:10]))
+}
+
+
+// annotate adds the id and any notes requested about a group.
+func annotate(group *Group) {
+	if *GroupIDs {
+		group.ID = groupID(group.Hash)
+	}
+	if *HashNames {
+		group.Notes = append(group.Notes, nameNote(group.Files))
+	}
+	if *FlagCaseVariants && hasCaseVariants(group.Files) {
+		group.Notes = append(group.Notes, "case-variants")
+	}
+	if splitLinkHashes[group.Hash] {
+		group.Notes = append(group.Notes, "split-hardlinks")
+	}
+	if *DiskUsage {
+		group.Notes = append(group.Notes, diskUsageNote(*group))
+	}
+	if *Suggest {
+		group.Notes = append(group.Notes, keepSuggestion(group.Files))
+	}
+}
+
+
+// hasCaseVariants reports whether any two files of a group have paths, relative to their
+// roots, which only differ in case: the mark of a copy between case-insensitive and
+// case-sensitive systems.
+func hasCaseVariants(files []string) bool {
+	seen := make(map[string]string, len(files))
+	for _, file := range files {
+		relative := relativePath(file)
+		folded := strings.ToLower(relative)
+		if other, ok := seen[folded]; ok && other != relative {
+			return true
+		}
+		seen[folded] = relative
+	}
+	return false
+}
+
+
+// nameNote describes whether the files of a group all share the same name ("copied") or not
+// ("renamed").
+func nameNote(files []string) string {
+	name := baseName(files[0])
+	for _, file := range files[1:] {
+		if baseName(file) != name {
+			return "renamed"
+		}
+	}
+	return "copied"
+}
+
+
+// hashSize decodes the file size from the front of a bucket key.
+func hashSize(hash string) int64 {
+	if len(hash) < 16 {
+		return 0
+	}
+	size, err := strconv.ParseInt(hash[:16], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+
+// reportFilter is the compiled --report-filter, or nil.
+var reportFilter *regexp.Regexp
+
+
+// parseReportFilter compiles --report-filter, if one was given.
+func parseReportFilter() error {
+	if *ReportFilter == "" {
+		return nil
+	}
+	filter, err := regexp.Compile(*ReportFilter)
+	if err != nil {
+		return err
+	}
+	reportFilter = filter
+	return nil
+}
+
+
+// matchesFilter reports whether a file's path matches --report-filter and its extension is
+// one of --report-ext, where they were given.
+func matchesFilter(file string) bool {
+	if reportFilter != nil && !reportFilter.MatchString(file) {
+		return false
+	}
+	if len(*ReportExtensions) == 0 {
+		return true
+	}
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), ".")
+	for _, candidate := range *ReportExtensions {
+		if strings.TrimPrefix(strings.ToLower(candidate), ".") == ext {
+			return true
+		}
+	}
+	return false
+}
+
+
+// passesFilter reports whether any of a group's files, or with --report-filter-all every one
+// of them, match --report-filter and --report-ext.
+func passesFilter(files []string) bool {
+	if reportFilter == nil && len(*ReportExtensions) == 0 {
+		return true
+	}
+	for _, file := range files {
+		if matchesFilter(file) != *ReportFilterAll {
+			// The first file that decides it: a match for any, a miss for all.
+			return !*ReportFilterAll
+		}
+	}
+	return *ReportFilterAll
+}
+
+
+// isReportable reports whether a group has enough files, and wastes enough space, to pass
+// --min-count and --min-dupe-bytes, with --cross-root-only, spans more than one root, and
+// passes --report-filter and --report-ext.
+func isReportable(group Group) bool {
+	if *CrossRootOnly && !spansRoots(group.Files) {
+		return false
+	}
+	if !passesFilter(group.Files) {
+		return false
+	}
+	return group.Count() >= *MinCount && group.WastedBytes() >= *MinDupeBytes
+}
+
+
+// sortedGroups returns the buckets of a collision table as Groups, ordered by hash.
+func sortedGroups(collisions CollisionTable) []Group {
+	hashes := make([]string, 0, len(collisions))
+	for hash := range collisions {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	groups := make([]Group, 0, len(hashes))
+	for _, hash := range hashes {
+		if group := newGroup(hash, collisions[hash]); isReportable(group) {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+
+// limitedGroups counts the groups left out by --limit-groups, for the footer.
+var limitedGroups int
+
+
+// limitGroups returns the --limit-groups groups which waste the most space, largest first.
+func limitGroups(groups []Group) []Group {
+	if *LimitGroups == 0 || len(groups) <= *LimitGroups {
+		return groups
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].WastedBytes() > groups[j].WastedBytes() })
+	limitedGroups = len(groups) - *LimitGroups
+	return groups[:*LimitGroups]
+}
+
+
+// feedGroups sends a list of groups to a channel in the background.
+func feedGroups(groups []Group) <-chan Group {
+	feed := make(chan Group, 64)
+	go func() {
+		defer close(feed)
+		for _, group := range groups {
+			feed <- group
+		}
+	}()
+	return feed
+}
+
+
+// streamGroups feeds the buckets of a collision table to a channel in the background, ordered
+// by hash so that the output is repeatable, removing each one from the table once it has been
+// sent so that the reporter is the only one holding onto it.
+func streamGroups(collisions CollisionTable) <-chan Group {
+	groups := make(chan Group, 64)
+
+	hashes := make([]string, 0, len(collisions))
+	for hash := range collisions {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	go func() {
+		defer close(groups)
+		for _, hash := range hashes {
+			files := collisions[hash]
+			delete(collisions, hash)
+			if group := newGroup(hash, files); isReportable(group) {
+				groups <- group
+			}
+		}
+	}()
+
+	return groups
+}
+
+
+// actionTag returns the --annotate-actions tag for the file at index i of a group: the first
+// file is the one the actions would keep.
+func actionTag(i int) string {
+	switch {
+	case !*AnnotateActions:
+		return ""
+	case i == 0:
+		return "KEEP "
+	default:
+		return "DROP "
+	}
+}
+
+
+// groupWriter writes groups out, one at a time, in the selected format.
+type groupWriter struct {
+	reported  int
+	jsonArray bool
+	// tree accumulates the groups for the json-tree format.
+	tree *TreeNode
+	// flat accumulates the files of every group for the flat-sorted format.
+	flat []string
+}
+
+
+// newGroupWriter creates a groupWriter, writing the start of the output if needed.
+func newGroupWriter() *groupWriter {
+	writer := &groupWriter{jsonArray: *Format == "json" && reportTemplate == nil}
+	if *Format == "json-tree" && reportTemplate == nil {
+		// With several roots, each gets its own tree under an unnamed top level.
+		writer.tree = &TreeNode{}
+		if len(roots) == 1 {
+			writer.tree.Name = roots[0]
+		}
+	}
+	if writer.jsonArray {
+		fmt.Println("[")
+	}
+	return writer
+}
+
+
+// write outputs a single group.
+func (w *groupWriter) write(group Group) {
+	switch {
+	case reportTemplate != nil:
+		if err := reportTemplate.Execute(os.Stdout, group); err != nil {
+			log.Printf("error executing template for group %s: %s", group.Hash, err.Error())
+		}
+	case *Format == "json":
+		record, err := json.MarshalIndent(group, "  ", "  ")
+		if err != nil {
+			log.Printf("error encoding group %s: %s", group.Hash, err.Error())
+			return
+		}
+		if w.reported > 0 {
+			fmt.Println(",")
+		}
+		fmt.Printf("  %s", record)
+	case *Format == "json-stream":
+		record, err := json.Marshal(group)
+		if err != nil {
+			log.Printf("error encoding group %s: %s", group.Hash, err.Error())
+			return
+		}
+		fmt.Printf("%s\n", record)
+	case *Format == "yaml":
+		// Each group is written as an item of a single top-level sequence.
+		record, err := yaml.Marshal([]Group{group})
+		if err != nil {
+			log.Printf("error encoding group %s: %s", group.Hash, err.Error())
+			return
+		}
+		fmt.Print(string(record))
+	case w.tree != nil:
+		w.tree.addGroup(group)
+	case *Format == "flat-sorted":
+		w.flat = append(w.flat, group.Files...)
+	case *Format == "fdupes":
+		// One path per line, unquoted, with a blank line after each group.
+		for i, file := range group.Files {
+			fmt.Println(actionTag(i) + file)
+		}
+		fmt.Println()
+	default:
+		if w.reported > 0 {
+			fmt.Print(groupSeparator)
+		}
+		if group.ID != "" {
+			fmt.Printf("[group: %s]\n", group.ID)
+		}
+		for _, note := range group.Notes {
+			fmt.Printf("# %s\n", note)
+		}
+		quoted := make([]string, len(group.Files))
+		for i, file := range group.Files {
+			quoted[i] = actionTag(i) + strconv.Quote(file)
+		}
+		fmt.Print(strings.Join(quoted, fileSeparator), "\n")
+	}
+	w.reported++
+}
+
+
+// close writes the end of the output if needed.
+func (w *groupWriter) close() {
+	if w.tree != nil {
+		writeTree(w.tree)
+	}
+	if *Format == "flat-sorted" && reportTemplate == nil {
+		sort.Strings(w.flat)
+		for _, file := range w.flat {
+			fmt.Println(file)
+		}
+	}
+	if w.jsonArray {
+		if w.reported > 0 {
+			fmt.Println()
+		}
+		fmt.Println("]")
+	}
+	if *Format == "yaml" && reportTemplate == nil && w.reported == 0 {
+		fmt.Println("[]")
+	}
+}
+
+
+// reportCollisions will output a report of which files collided, writing each group as it
+// arrives rather than building up the whole report first.
+func reportCollisions(groups <-chan Group, writer *groupWriter) {
+	for group := range groups {
+		writer.write(group)
+	}
+	writer.close()
+
+	if limitedGroups > 0 {
+		log.Print("... and ", formatCount(int64(limitedGroups)), " more groups (use --limit-groups 0 for all)")
+	}
+}
+
+
+// dumpHashes writes out every reply as it arrives, without waiting for or holding onto the
+// rest of the tree. JSON is written as an array, one record per line.
+func dumpHashes(replies <-chan *FileHash) {
+	var dumped int64
+
+	if *Format == "json" {
+		fmt.Println("[")
+	}
+	for reply := range replies {
+		switch *Format {
+		case "json":
+			record, err := json.Marshal(HashRecord{Path: reply.Pathname, Size: reply.Size, ModTime: reply.ModTime, Hash: reply.Hash})
+			if err != nil {
+				log.Printf("error encoding %s: %s", reply.Pathname, err.Error())
+				continue
+			}
+			if dumped > 0 {
+				fmt.Println(",")
+			}
+			fmt.Printf("  %s", record)
+		case "yaml":
+			record, err := yaml.Marshal([]HashRecord{{Path: reply.Pathname, Size: reply.Size, ModTime: reply.ModTime, Hash: reply.Hash}})
+			if err != nil {
+				log.Printf("error encoding %s: %s", reply.Pathname, err.Error())
+				continue
+			}
+			fmt.Print(string(record))
+		default:
+			fmt.Printf("%s %q\n", reply.Hash, reply.Pathname)
+		}
+		dumped++
+	}
+	if *Format == "json" {
+		if dumped > 0 {
+			fmt.Println()
+		}
+		fmt.Println("]")
+	}
+	if *Format == "yaml" && dumped == 0 {
+		fmt.Println("[]")
+	}
+
+	log.Print("Dumped:", dumped)
+}
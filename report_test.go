@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+
+// captureStdout returns everything written to standard output while report runs.
+func captureStdout(t *testing.T, report func()) string {
+	t.Helper()
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	captured := make(chan string)
+	go func() {
+		output, _ := io.ReadAll(reader)
+		captured <- string(output)
+	}()
+
+	report()
+	os.Stdout = stdout
+	writer.Close()
+	return <-captured
+}
+
+
+func TestSingleLineReport(t *testing.T) {
+	setFlag(t, "single-line", "true")
+	if err := parseSeparators(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { groupSeparator, fileSeparator = "", "" }()
+
+	output := captureStdout(t, func() {
+		writer := newGroupWriter()
+		writer.write(Group{Hash: "one", Size: 10, Files: []string{"a/one", "b/one"}})
+		writer.write(Group{Hash: "two", Size: 20, Files: []string{"a/two", "b/two", "c/two"}})
+		writer.close()
+	})
+
+	want := `"a/one" "b/one"` + "\n" + `"a/two" "b/two" "c/two"` + "\n"
+	if output != want {
+		t.Errorf("output %q, want %q", output, want)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		if strings.TrimLeft(line, " \t") != line {
+			t.Errorf("%q starts with whitespace", line)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+// The roots of the crawl, and response files.
+//
+// Any arguments after the options are further directories to recurse over, alongside --path
+// if it was given; if none are, --path (by default the current directory) is the only root.
+//
+// An argument of the form @filename is replaced by the arguments in that file, one per line,
+// before the command line is parsed. Blank lines are skipped, and a line may be wrapped in
+// double quotes, with Go-style escapes, or single quotes, taken literally, to preserve leading
+// or trailing spaces.
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+
+// maxResponseDepth limits how deeply response files may refer to other response files.
+const maxResponseDepth = 8
+
+
+// roots is the list of directories to recurse over.
+var roots []string
+
+// walkingRoot is the root currently being walked.
+var walkingRoot string
+
+
+// expandResponseFiles replaces any @filename arguments with the contents of the file.
+func expandResponseFiles(args []string, depth int) ([]string, error) {
+	expanded := make([]string, 0, len(args))
+	for _, arg := range args {
+		if len(arg) < 2 || arg[0] != '@' {
+			expanded = append(expanded, arg)
+			continue
+		}
+		if depth >= maxResponseDepth {
+			return nil, fmt.Errorf("%s: response files nested too deeply", arg)
+		}
+
+		lines, err := readResponseFile(arg[1:])
+		if err != nil {
+			return nil, err
+		}
+		lines, err = expandResponseFiles(lines, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, lines...)
+	}
+	return expanded, nil
+}
+
+
+// readResponseFile reads the arguments in a response file, one per line.
+func readResponseFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var args []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		arg := strings.TrimSpace(scanner.Text())
+		switch {
+		case arg == "":
+			continue
+		case len(arg) >= 2 && arg[0] == '"' && arg[len(arg)-1] == '"':
+			unquoted, err := strconv.Unquote(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", filename, line, err)
+			}
+			arg = unquoted
+		case len(arg) >= 2 && arg[0] == '\'' && arg[len(arg)-1] == '\'':
+			arg = arg[1 : len(arg)-1]
+		}
+		args = append(args, arg)
+	}
+
+	return args, scanner.Err()
+}
+
+
+// setRoots determines the roots from --path and the remaining arguments.
+func setRoots(args []string, pathGiven bool) {
+	if len(args) == 0 || pathGiven {
+		args = append([]string{*BasePath}, args...)
+	}
+	// A root given twice would have every file under it match itself.
+	for _, root := range args {
+		if !isRoot(root) {
+			roots = append(roots, root)
+		}
+	}
+}
+
+
+// checkRoots makes sure every root exists and is a directory.
+func checkRoots() error {
+	for _, root := range roots {
+		info, err := fileSystem.Stat(root)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", root)
+		}
+	}
+	return nil
+}
+
+
+// isRoot reports whether path is one of the roots.
+func isRoot(path string) bool {
+	path = filepath.Clean(path)
+	for _, root := range roots {
+		if filepath.Clean(root) == path {
+			return true
+		}
+	}
+	return false
+}
+
+
+// isOtherRoot reports whether a directory is a root in its own right, other than the one
+// being walked, and so will be walked separately.
+func isOtherRoot(dir string) bool {
+	return isRoot(dir) && filepath.Clean(dir) != filepath.Clean(walkingRoot)
+}
+
+
+// visitedDirs are the directories walked so far, by device and inode, and the path each was
+// first walked under.
+var visitedDirs = make(map[[2]uint64]string)
+
+
+// isRevisit reports whether a directory has already been walked under another path, as it
+// would be through a bind mount, a root given twice under different names, or a root inside
+// another one reached through a followed link. Walking it again would only have every file
+// under it match itself.
+func isRevisit(dir string, info os.FileInfo) bool {
+	id, ok := fileID(info)
+	if !ok {
+		return false
+	}
+	if first, seen := visitedDirs[id]; seen {
+		log.Printf("Skipping %s, which is %s again", dir, first)
+		return true
+	}
+	visitedDirs[id] = dir
+	return false
+}
+
+
+// spansRoots reports whether files were found under at least two different roots.
+func spansRoots(files []string) bool {
+	for _, file := range files[1:] {
+		if rootOf(file) != rootOf(files[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+
+// isWithin returns path relative to root, and whether it is actually under root.
+func isWithin(root, path string) (string, bool) {
+	relative, err := filepath.Rel(filepath.FromSlash(root), filepath.FromSlash(path))
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return relative, true
+}
+
+
+// rootOf returns the root a path was found under, preferring the deepest one if roots are
+// nested, or an empty string if it isn't under any of them.
+func rootOf(path string) string {
+	best := ""
+	for _, root := range roots {
+		if _, ok := isWithin(root, path); ok && len(root) > len(best) {
+			best = root
+		}
+	}
+	return best
+}
+
+
+// relativePath returns path relative to the root it was found under, in slash form, or path
+// itself if it isn't under any of them.
+func relativePath(path string) string {
+	relative, ok := isWithin(rootOf(path), path)
+	if !ok {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(relative)
+}
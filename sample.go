@@ -0,0 +1,49 @@
+package main
+
+// Scanning a sample of the files, for --sample-rate.
+//
+// To get a ballpark of how much a full scan would find, only a fraction of the files are
+// hashed and the space their duplicates waste is scaled up. The sample is taken by size rather
+// than by path: copies of a file are the same size, so each group is either sampled whole or
+// not at all, whereas sampling paths would catch both files of a pair only rate² of the time
+// and make the estimate come out low. Sizes are hashed, so that the same sizes are picked on
+// every run.
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"log"
+	"math"
+)
+
+
+// sampledOutFiles counts the files left out of the sample.
+var sampledOutFiles int64
+
+
+// isSampled reports whether files of a size are in the --sample-rate sample.
+func isSampled(size int64) bool {
+	if *SampleRate >= 1 {
+		return true
+	}
+	var key [8]byte
+	binary.LittleEndian.PutUint64(key[:], uint64(size))
+	hasher := fnv.New64a()
+	hasher.Write(key[:])
+	return float64(hasher.Sum64()) < *SampleRate*math.MaxUint64
+}
+
+
+// logSampleEstimate logs the space the duplicates waste across the whole tree, as estimated
+// from the sample.
+func logSampleEstimate(collisions CollisionTable) {
+	var wasted, duplicates int64
+	for hash, files := range collisions {
+		// The hash has no size to go by with --compare-contents-only, but the group does.
+		wasted += newGroup(hash, files).WastedBytes()
+		duplicates += int64(len(files) - 1)
+	}
+	log.Printf("Estimate based on a %g%% sample of file sizes: about %s reclaimable in about %s duplicate files (%s in %s files in the sample)",
+		*SampleRate*100, formatBytes(int64(float64(wasted) / *SampleRate)), formatCount(int64(float64(duplicates) / *SampleRate)),
+		formatBytes(wasted), formatCount(duplicates))
+}
@@ -0,0 +1,202 @@
+package main
+
+// Serving the results over HTTP, for --serve.
+//
+// Once the scan is done the reportable groups are held by a groupServer rather than printed,
+// and served as JSON until the process is interrupted:
+//
+//	/stats           the counts from the summary, and the totals of the groups
+//	/groups          the groups, a page at a time (?offset=&limit=), optionally only those with
+//	                 at least ?min-count= files or ?min-wasted= bytes to reclaim, or with a file
+//	                 whose path contains ?path=, and ordered by hash or by ?sort=wasted
+//	/group/<hash>    a single group, by its hash, or by its id with --group-ids
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+
+// defaultPageSize and maxPageSize are how many groups /groups returns when no limit is given,
+// and at most.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 10000
+)
+
+
+// serverStats is the body of /stats.
+type serverStats struct {
+	Files       int64 `json:"files"`
+	Hashed      int64 `json:"hashed"`
+	Excluded    int64 `json:"excluded"`
+	Undersized  int64 `json:"undersized"`
+	Groups      int   `json:"groups"`
+	Duplicates  int64 `json:"duplicates"`
+	WastedBytes int64 `json:"wasted_bytes"`
+}
+
+
+// groupPage is the body of /groups.
+type groupPage struct {
+	Total  int     `json:"total"`
+	Offset int     `json:"offset"`
+	Limit  int     `json:"limit"`
+	Groups []Group `json:"groups"`
+}
+
+
+// groupServer holds the results being served; they don't change once serving starts.
+type groupServer struct {
+	groups []Group
+	// byHash indexes the groups by their hashes, and by their ids too if they have them.
+	byHash map[string]int
+	stats  serverStats
+}
+
+
+// newGroupServer prepares a set of groups for serving.
+func newGroupServer(groups []Group) *groupServer {
+	server := &groupServer{groups: groups, byHash: make(map[string]int, len(groups))}
+	server.stats = serverStats{
+		Files:      totalFiles,
+		Hashed:     atomic.LoadInt64(&hashingFiles),
+		Excluded:   excludedFiles,
+		Undersized: underSizedFiles,
+		Groups:     len(groups),
+	}
+	for i, group := range groups {
+		server.byHash[group.Hash] = i
+		if group.ID != "" {
+			server.byHash[group.ID] = i
+		}
+		server.stats.Duplicates += int64(group.Count() - 1)
+		server.stats.WastedBytes += group.WastedBytes()
+	}
+	return server
+}
+
+
+// writeJSON sends a value as the JSON body of a response.
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("error writing response: %s", err.Error())
+	}
+}
+
+
+// queryInt returns an integer query parameter, or fallback if it is missing; ok is false if it
+// isn't a non-negative integer.
+func queryInt(r *http.Request, name string, fallback int64) (value int64, ok bool) {
+	text := r.URL.Query().Get(name)
+	if text == "" {
+		return fallback, true
+	}
+	value, err := strconv.ParseInt(text, 10, 64)
+	return value, err == nil && value >= 0
+}
+
+
+// handleStats serves /stats.
+func (s *groupServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.stats)
+}
+
+
+// handleGroups serves /groups.
+func (s *groupServer) handleGroups(w http.ResponseWriter, r *http.Request) {
+	offset, offsetOK := queryInt(r, "offset", 0)
+	limit, limitOK := queryInt(r, "limit", defaultPageSize)
+	minCount, minCountOK := queryInt(r, "min-count", 0)
+	minWasted, minWastedOK := queryInt(r, "min-wasted", 0)
+	if !offsetOK || !limitOK || !minCountOK || !minWastedOK {
+		http.Error(w, "offset, limit, min-count and min-wasted must be non-negative integers", http.StatusBadRequest)
+		return
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	path := r.URL.Query().Get("path")
+
+	matched := make([]Group, 0, len(s.groups))
+	for _, group := range s.groups {
+		if int64(group.Count()) < minCount || group.WastedBytes() < minWasted {
+			continue
+		}
+		if path != "" && !hasPathContaining(group.Files, path) {
+			continue
+		}
+		matched = append(matched, group)
+	}
+	switch r.URL.Query().Get("sort") {
+	case "", "hash":
+	case "wasted":
+		sort.SliceStable(matched, func(i, j int) bool { return matched[i].WastedBytes() > matched[j].WastedBytes() })
+	default:
+		http.Error(w, "sort must be hash or wasted", http.StatusBadRequest)
+		return
+	}
+
+	page := groupPage{Total: len(matched), Offset: int(offset), Limit: int(limit), Groups: []Group{}}
+	if offset < int64(len(matched)) {
+		end := offset + limit
+		if end > int64(len(matched)) {
+			end = int64(len(matched))
+		}
+		page.Groups = matched[offset:end]
+	}
+	writeJSON(w, page)
+}
+
+
+// hasPathContaining reports whether any of the files has text in its path.
+func hasPathContaining(files []string, text string) bool {
+	for _, file := range files {
+		if strings.Contains(file, text) {
+			return true
+		}
+	}
+	return false
+}
+
+
+// handleGroup serves /group/<hash>.
+func (s *groupServer) handleGroup(w http.ResponseWriter, r *http.Request) {
+	i, ok := s.byHash[strings.TrimPrefix(r.URL.Path, "/group/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, s.groups[i])
+}
+
+
+// serveGroups serves the groups on addr until the process is interrupted.
+func serveGroups(addr string, groups []Group) error {
+	server := newGroupServer(groups)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", server.handleStats)
+	mux.HandleFunc("/groups", server.handleGroups)
+	mux.HandleFunc("/group/", server.handleGroup)
+
+	httpServer := &http.Server{Addr: addr, Handler: mux}
+	served := make(chan error, 1)
+	go func() {
+		served <- httpServer.ListenAndServe()
+	}()
+	log.Print("Serving ", len(groups), " groups on ", addr, "; interrupt to stop")
+
+	select {
+	case err := <-served:
+		return err
+	case <-interruptCh:
+		return httpServer.Shutdown(context.Background())
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+// Walking from an index of file sizes, for --size-index.
+//
+// On a high-latency mount the walk itself is the slow part, as every directory has to be read
+// and every file stat'd before anything is hashed. Given a list of sizes and paths made ahead
+// of time (say by `find /mnt/nas -type f -printf '%s %p\n'` on the server itself), the tree
+// isn't walked at all: the sizes drive --min-bytes and the rest of the filters, files whose
+// size nothing else shares are dropped without being touched, and only the files left are
+// stat'd, just before they are hashed. A file whose size differs from its index entry is
+// warned about and hashed at its real size.
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+
+// indexEntry is a file listed in the size index.
+type indexEntry struct {
+	path string
+	size int64
+}
+
+
+// sizeIndex is the index given to --size-index, if any.
+var sizeIndex []indexEntry
+
+
+// loadSizeIndex reads an index of "size path" lines.
+func loadSizeIndex(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		space := strings.IndexAny(line, " \t")
+		if space < 0 {
+			return fmt.Errorf("%s:%d: expected a size and a path", filename, lineNo)
+		}
+		size, err := strconv.ParseInt(line[:space], 10, 64)
+		if err != nil || size < 0 {
+			return fmt.Errorf("%s:%d: bad size %q", filename, lineNo, line[:space])
+		}
+		sizeIndex = append(sizeIndex, indexEntry{path: filepath.Clean(line[space+1:]), size: size})
+	}
+	return scanner.Err()
+}
+
+
+// isIndexExcluded reports whether an indexed file, or any directory between it and its root,
+// is excluded, as the walk would have skipped it.
+func isIndexExcluded(path string) bool {
+	root := filepath.Clean(rootOf(path))
+	for dir := path; dir != root; dir = filepath.Dir(dir) {
+		if isExcluded(dir) || isOwnFile(dir, nil) || (dir != path && isHiddenDir(dir)) {
+			return true
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	return false
+}
+
+
+// walkSizeIndex dispatches the files in the index which are under the roots, applying the
+// walk's filters to them by their indexed size.
+func walkSizeIndex() {
+	var candidates []indexEntry
+	var outside, unique int64
+	for _, entry := range sizeIndex {
+		path := entry.path
+		if rootOf(path) == "" {
+			outside++
+			continue
+		}
+
+		totalFiles++
+		if isIndexExcluded(path) {
+			excludedFiles++
+			continue
+		}
+		if entry.size == 0 && !*IncludeEmpty {
+			emptyFiles++
+			continue
+		}
+		if entry.size > 0 && entry.size < int64(*MinBytes) {
+			underSizedFiles++
+			continue
+		}
+		if (*Offset > 0 || *Length > 0) && (entry.size <= *Offset || entry.size < *Offset+*Length) {
+			shortFiles++
+			continue
+		}
+		candidates = append(candidates, entry)
+	}
+
+	// Files of a size no other file has can't have a duplicate, unless matching isn't by size
+	// or every file's hash is needed.
+	sizeCounts := make(map[int64]int)
+	for _, entry := range candidates {
+		sizeCounts[entry.size]++
+	}
+	keepUnique := *ContentsOnly || *TextNormalize || *ArchiveAware || *Dirs || *TreeHash || *DumpHashes || *Cache != ""
+
+	for _, entry := range candidates {
+		if isInterrupted() {
+			break
+		}
+		if !keepUnique && sizeCounts[entry.size] < 2 {
+			unique++
+			continue
+		}
+		if isSkippedType(entry.path) {
+			continue
+		}
+
+		atomic.AddInt64(&hashingFiles, 1)
+		atomic.AddInt64(&dispatchedBytes, entry.size)
+		dispatch(&FileHash{Pathname: entry.path, Size: entry.size})
+	}
+
+	log.Print("Size index: ", len(sizeIndex), " files listed, ", outside, " outside the roots, ", unique, " left out for having a unique size")
+}
+
+
+// sizeIndexHasher wraps a hasher to stat each file before it is hashed, filling in what the
+// index doesn't say and warning when its size has changed.
+func sizeIndexHasher(hasher Hasher) Hasher {
+	return func(request *FileHash) *FileHash {
+		info, err := fileSystem.Lstat(request.Pathname)
+		if err != nil {
+			readError(request.Pathname, err)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			readError(request.Pathname, fmt.Errorf("not a regular file"))
+			return nil
+		}
+		if info.Size() != request.Size {
+			log.Printf("warning: %s is %d bytes, not the %d bytes in the size index", request.Pathname, info.Size(), request.Size)
+			request.Size = info.Size()
+		}
+		request.ModTime = info.ModTime()
+		request.Mode = info.Mode().Perm()
+		if *IncludeOwner {
+			request.Owner = fileOwner(info)
+		}
+		return hasher(request)
+	}
+}
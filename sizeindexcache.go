@@ -0,0 +1,287 @@
+package main
+
+// Remembering the walk between runs, for --size-index-cache.
+//
+// Scanning the same large tree every day, most of its directories haven't changed since the
+// last run, yet each one is read again and every file in it stat'd just to learn the sizes
+// which were already known. With --size-index-cache, the listing of every directory walked -
+// the names, sizes, times and modes of its entries - is written to a JSON Lines file at the end
+// of the run, along with the directory's own modification time. On the next run, a directory
+// whose modification time still matches has its entries taken from the file instead: it is
+// stat'd, but neither read nor are its files. If the run is interrupted, the listings of the
+// directories it didn't get to are carried forward from the last run.
+//
+// A directory's modification time only changes when an entry is added to it, removed or
+// renamed, not when one of its files is written to; so the listing of a file edited in place is
+// out of date. Every file which goes on to be hashed is stat'd again first, and hashed (and
+// looked up in the hash cache) at its real size and time, but the filters ahead of that, such
+// as --min-bytes, see the size it was listed with.
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+
+// listingSettle is how long a directory has to have been left alone for its listing to be
+// kept, so that a change made within the same tick of its modification time isn't missed.
+const listingSettle = 2 * time.Second
+
+
+// ListingEntry is one entry of a directory in the size index cache.
+type ListingEntry struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	Mode    os.FileMode `json:"mode"`
+}
+
+// DirListing is a directory's record in the size index cache.
+type DirListing struct {
+	Path    string         `json:"path"`
+	ModTime time.Time      `json:"mtime"`
+	Entries []ListingEntry `json:"entries"`
+}
+
+
+// listingHeader is the first line of a size index cache file.
+type listingHeader struct {
+	Version int `json:"findupe-size-index-cache"`
+}
+
+
+// cachedListings is the size index cache as loaded at startup, and freshListings the listings
+// to be written back, both keyed by path.
+var cachedListings = make(map[string]*DirListing)
+var freshListings = make(map[string]*DirListing)
+
+// reusedDirs and readDirs count the directories whose listings came from the cache and those
+// which had to be read.
+var reusedDirs, readDirs int64
+
+
+// listingInfo presents a listing entry as an os.FileInfo for walkFn; there is nothing behind it
+// for Sys to return.
+type listingInfo struct {
+	entry *ListingEntry
+}
+
+func (i listingInfo) Name() string       { return i.entry.Name }
+func (i listingInfo) Size() int64        { return i.entry.Size }
+func (i listingInfo) Mode() os.FileMode  { return i.entry.Mode }
+func (i listingInfo) ModTime() time.Time { return i.entry.ModTime }
+func (i listingInfo) IsDir() bool        { return i.entry.Mode.IsDir() }
+func (i listingInfo) Sys() interface{}   { return nil }
+
+
+// loadListings reads the size index cache, if there is one, into cachedListings.
+func loadListings(filename string) error {
+	file, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	var header listingHeader
+	if err := decoder.Decode(&header); err == io.EOF {
+		return nil
+	} else if err != nil || header.Version != 1 {
+		return fmt.Errorf("%s is not a findupe size index cache", filename)
+	}
+
+	for {
+		listing := &DirListing{}
+		if err := decoder.Decode(listing); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %s", filename, err.Error())
+		}
+		cachedListings[listing.Path] = listing
+	}
+}
+
+
+// saveListings writes the listings of the directories walked this run to the size index cache.
+func saveListings(filename string) error {
+	temporary := filename + ".tmp"
+	file, err := os.Create(temporary)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	encoder.Encode(listingHeader{Version: 1})
+	for _, listing := range freshListings {
+		if err := encoder.Encode(listing); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	carried := 0
+	if isInterrupted() {
+		for path, listing := range cachedListings {
+			if _, ok := freshListings[path]; ok {
+				continue
+			}
+			if err := encoder.Encode(listing); err != nil {
+				file.Close()
+				return err
+			}
+			carried++
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if isInterrupted() {
+		log.Print("Size index cache: ", len(freshListings)+carried, " directories, reused:", reusedDirs, ", read:", readDirs, ", carried forward:", carried)
+	} else {
+		log.Print("Size index cache: ", len(freshListings), " directories, reused:", reusedDirs, ", read:", readDirs)
+	}
+
+	return os.Rename(temporary, filename)
+}
+
+
+// readListing reads a directory and stats each of its entries.
+func readListing(dir string, info os.FileInfo) (*DirListing, error) {
+	names, err := fileSystem.ReadDirNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	readDirs++
+
+	listing := &DirListing{Path: dir, ModTime: info.ModTime(), Entries: make([]ListingEntry, 0, len(names))}
+	for _, name := range names {
+		entry := ListingEntry{Name: name}
+		if info, err := fileSystem.Lstat(filepath.Join(dir, name)); err == nil {
+			entry.Size, entry.ModTime, entry.Mode = info.Size(), info.ModTime(), info.Mode()
+		} else {
+			// Leave it to the walk to report, as filepath.Walk would.
+			entry.Mode = os.ModeIrregular
+		}
+		listing.Entries = append(listing.Entries, entry)
+	}
+	if time.Since(info.ModTime()) >= listingSettle {
+		freshListings[dir] = listing
+	}
+	return listing, nil
+}
+
+
+// walkListings walks a root as filepath.Walk does, taking the entries of each directory which
+// hasn't changed since the last run from the size index cache.
+func walkListings(root string, fn filepath.WalkFunc) error {
+	info, err := fileSystem.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkListing(root, info, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+
+// walkListing walks one directory of walkListings.
+func walkListing(dir string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(dir, info, nil)
+	}
+	if err := fn(dir, info, nil); err != nil {
+		return err
+	}
+
+	listing := cachedListings[dir]
+	if listing != nil && listing.ModTime.Equal(info.ModTime()) {
+		reusedDirs++
+		freshListings[dir] = listing
+	} else {
+		var err error
+		if listing, err = readListing(dir, info); err != nil {
+			return fn(dir, info, err)
+		}
+	}
+
+	for i := range listing.Entries {
+		entry := &listing.Entries[i]
+		path := filepath.Join(dir, entry.Name)
+
+		var err error
+		switch {
+		case entry.Mode.IsDir():
+			// A directory is still stat'd, for the time its own listing is checked against.
+			var dirInfo os.FileInfo
+			if dirInfo, err = fileSystem.Lstat(path); err != nil {
+				err = fn(path, nil, err)
+			} else {
+				err = walkListing(path, dirInfo, fn)
+			}
+		case entry.Mode == os.ModeIrregular:
+			// The entry couldn't be stat'd when it was read, so try again for walkFn.
+			if fileInfo, lstatErr := fileSystem.Lstat(path); lstatErr != nil {
+				err = fn(path, nil, lstatErr)
+			} else {
+				err = fn(path, fileInfo, nil)
+			}
+		default:
+			err = fn(path, listingInfo{entry}, nil)
+		}
+
+		if err == filepath.SkipDir {
+			if !entry.Mode.IsDir() {
+				// Skipping from a file skips the rest of its directory.
+				return nil
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+
+// listingHasher wraps a hasher to stat each file before it is hashed, as its listing may be
+// out of date, dropping the cached hash looked up for it if it has changed since.
+func listingHasher(hasher Hasher) Hasher {
+	return func(request *FileHash) *FileHash {
+		if request.IsLink {
+			return hasher(request)
+		}
+		info, err := fileSystem.Stat(request.Pathname)
+		if err != nil {
+			readError(request.Pathname, err)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			readError(request.Pathname, fmt.Errorf("not a regular file"))
+			return nil
+		}
+		if info.Size() != request.Size || !info.ModTime().Equal(request.ModTime) {
+			request.Size, request.ModTime, request.CachedHash = info.Size(), info.ModTime(), ""
+		}
+		request.Mode = info.Mode().Perm()
+		if *IncludeOwner {
+			request.Owner = fileOwner(info)
+		}
+		return hasher(request)
+	}
+}
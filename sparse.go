@@ -0,0 +1,114 @@
+package main
+
+// Reading sparse files without reading their holes, for --sparse.
+//
+// VM images and database files are often mostly holes: ranges the filesystem has never
+// allocated, which read back as zeros. Where the OS can say where the data is (SEEK_DATA and
+// SEEK_HOLE), only the allocated ranges are read from disk and the holes are produced as zeros
+// in memory. The content is exactly what a plain read would give, so the hashes are the same
+// as without --sparse, and a sparse file still matches a fully allocated copy of itself.
+
+import (
+	"io"
+	"log"
+	"sync/atomic"
+)
+
+
+// holeBytes counts the bytes that were produced as zeros rather than read; it is updated by the
+// workers, so use atomic operations.
+var holeBytes int64
+
+
+// sparseReader reads a file a data range at a time, filling in the holes between them.
+type sparseReader struct {
+	file File
+	// data is what the data ranges are read through, which may be throttled.
+	data io.Reader
+	// pos is the offset of the next byte, size the size of the file, and end the end of the
+	// range pos is in, which is a hole if inHole.
+	pos, size, end int64
+	inHole         bool
+}
+
+
+// newSparseReader reads the rest of a file from its current position, reading its data through
+// data.
+func newSparseReader(file File, data io.Reader) (io.Reader, error) {
+	pos, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(pos, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &sparseReader{file: file, data: data, pos: pos, size: size, end: pos}, nil
+}
+
+
+// nextRange finds the extent of the data or hole range starting at pos, leaving the file
+// positioned at pos.
+func (r *sparseReader) nextRange() error {
+	data, err := r.file.Seek(r.pos, seekData)
+	switch {
+	case err != nil:
+		// Either the rest of the file is a hole (ENXIO), or the filesystem can't say; only a
+		// read can tell them apart, so read it.
+		r.end, r.inHole = r.size, false
+	case data > r.pos:
+		r.end, r.inHole = data, true
+	default:
+		hole, err := r.file.Seek(r.pos, seekHole)
+		if err != nil || hole <= r.pos {
+			hole = r.size
+		}
+		r.end, r.inHole = hole, false
+	}
+	_, err = r.file.Seek(r.pos, io.SeekStart)
+	return err
+}
+
+
+// Read fills p from the current range, moving on to the next range once it is used up.
+func (r *sparseReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if r.pos >= r.end {
+		if err := r.nextRange(); err != nil {
+			return 0, err
+		}
+	}
+
+	if remaining := r.end - r.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	if r.inHole {
+		for i := range p {
+			p[i] = 0
+		}
+		r.pos += int64(len(p))
+		atomic.AddInt64(&holeBytes, int64(len(p)))
+		return len(p), nil
+	}
+
+	n, err := r.data.Read(p)
+	r.pos += int64(n)
+	if err == io.EOF && r.pos < r.size {
+		// The file shrank while it was being read; let the caller see that.
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+
+// logHoles logs how much of the files hashed were holes that didn't have to be read.
+func logHoles() {
+	if holes := atomic.LoadInt64(&holeBytes); holes > 0 {
+		log.Print("Sparse: ", formatBytes(holes), " of holes filled in without reading them.")
+	}
+}
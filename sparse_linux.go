@@ -0,0 +1,14 @@
+//go:build linux
+// +build linux
+
+package main
+
+
+// hasSparseSeek reports whether seekData and seekHole are available here.
+const hasSparseSeek = true
+
+// lseek's whence values for finding the next data and the next hole.
+const (
+	seekData = 3
+	seekHole = 4
+)
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+
+// hasSparseSeek reports whether seekData and seekHole are available here.
+const hasSparseSeek = false
+
+// seekData and seekHole are never used here.
+const (
+	seekData = -1
+	seekHole = -1
+)
@@ -0,0 +1,321 @@
+package main
+
+// Aggregation on disk, for --spill.
+//
+// Rather than holding the hash of every file in memory until the end, the replies are
+// gathered in memory in runs of up to --spill records, and each full run is sorted by hash and
+// written to a temporary file. Once everything has been hashed the runs are merged, which
+// brings the files with the same hash together, and only the collisions are kept in memory.
+// Memory then grows with the number of duplicates rather than the number of files, at the cost
+// of writing every path and hash to disk and reading them back once.
+//
+// Merging reads from every run at once, so each needs a file of its own open. Rather than run
+// out of descriptors on a tree of many runs, at most mergeFanIn of them are merged at a time,
+// into longer runs which are merged again in turn. Each extra pass writes and reads everything
+// once more, so a --spill large enough to need only the one pass is still much the quickest.
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+
+// spillPattern names the temporary files the runs are written to.
+const spillPattern = "findupe-spill-*"
+
+
+// spillFanIn is the most runs merged at once when there is no limit on open files.
+const spillFanIn = 128
+
+
+// spillRecord is a single file's hash, as spilled.
+type spillRecord struct {
+	hash string
+	path string
+}
+
+
+// less orders records by hash, then by path.
+func (r spillRecord) less(other spillRecord) bool {
+	if r.hash != other.hash {
+		return r.hash < other.hash
+	}
+	return r.path < other.path
+}
+
+
+// write writes the record to a run as a quoted hash and path on a line of its own.
+func (r spillRecord) write(writer io.Writer) {
+	fmt.Fprintf(writer, "%s\t%s\n", strconv.Quote(r.hash), strconv.Quote(r.path))
+}
+
+
+// writeRun sorts a run of records and writes it to a new temporary file in dir, one quoted
+// hash and path per line.
+func writeRun(dir string, records []spillRecord) (string, error) {
+	sort.Slice(records, func(i, j int) bool { return records[i].less(records[j]) })
+
+	file, err := os.CreateTemp(dir, spillPattern)
+	if err != nil {
+		return "", err
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, record := range records {
+		record.write(writer)
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return file.Name(), err
+	}
+	return file.Name(), file.Close()
+}
+
+
+// spillRun is a run being read back during the merge.
+type spillRun struct {
+	file    *os.File
+	scanner *bufio.Scanner
+	head    spillRecord
+}
+
+
+// next reads the run's next record into head, returning false at the end of the run.
+func (r *spillRun) next() (bool, error) {
+	if !r.scanner.Scan() {
+		return false, r.scanner.Err()
+	}
+	line := r.scanner.Text()
+	tab := strings.IndexByte(line, '\t')
+	if tab < 0 {
+		return false, fmt.Errorf("%s: malformed line", r.file.Name())
+	}
+	hash, err := strconv.Unquote(line[:tab])
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", r.file.Name(), err)
+	}
+	path, err := strconv.Unquote(line[tab+1:])
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", r.file.Name(), err)
+	}
+	r.head = spillRecord{hash: hash, path: path}
+	return true, nil
+}
+
+
+// runHeap orders the runs being merged by their next record.
+type runHeap []*spillRun
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].head.less(h[j].head) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*spillRun)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	run := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return run
+}
+
+
+// spillAggregate collects the replies like aggregateHashes, but keeps at most threshold of
+// them in memory at a time, spilling the rest to sorted runs in dir.
+func spillAggregate(replies <-chan *FileHash, threshold int, dir string) (CollisionTable, error) {
+	var runs []string
+	defer func() {
+		for _, run := range runs {
+			os.Remove(run)
+		}
+	}()
+
+	var hashedFiles int64
+	records := make([]spillRecord, 0, threshold)
+	for response := range replies {
+		hashedFiles++
+		records = append(records, spillRecord{hash: response.Hash, path: response.Pathname})
+		if len(records) < threshold {
+			continue
+		}
+		run, err := writeRun(dir, records)
+		if run != "" {
+			runs = append(runs, run)
+		}
+		if err != nil {
+			// Drain the rest so that the workers aren't left blocked.
+			for range replies {
+			}
+			return nil, err
+		}
+		records = records[:0]
+	}
+	if len(records) > 0 {
+		run, err := writeRun(dir, records)
+		if run != "" {
+			runs = append(runs, run)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	records = nil
+
+	collisions, misses, err := mergeRuns(runs, dir)
+	if err != nil {
+		return nil, err
+	}
+	logAggregate(hashedFiles, misses, len(collisions))
+	return collisions, nil
+}
+
+
+// mergeFanIn returns how many runs may be merged at once: spillFanIn, or fewer if that would
+// take more files than --max-open-files allows, leaving one for the run being written.
+func mergeFanIn() int {
+	fanIn := spillFanIn
+	if openFileSlots != nil && cap(openFileSlots)-1 < fanIn {
+		fanIn = cap(openFileSlots) - 1
+	}
+	if fanIn < 2 {
+		fanIn = 2
+	}
+	return fanIn
+}
+
+
+// mergeRecords merges sorted runs, passing each record to emit in order.
+func mergeRecords(runs []string, emit func(spillRecord)) error {
+	pending := make(runHeap, 0, len(runs))
+	defer func() {
+		for _, run := range pending {
+			run.file.Close()
+		}
+	}()
+
+	for _, name := range runs {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		run := &spillRun{file: file, scanner: bufio.NewScanner(file)}
+		run.scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		more, err := run.next()
+		if err != nil || !more {
+			file.Close()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		pending = append(pending, run)
+	}
+	heap.Init(&pending)
+
+	for pending.Len() > 0 {
+		run := pending[0]
+		emit(run.head)
+
+		more, err := run.next()
+		if err != nil {
+			return err
+		}
+		if more {
+			heap.Fix(&pending, 0)
+		} else {
+			heap.Pop(&pending)
+			run.file.Close()
+		}
+	}
+	return nil
+}
+
+
+// combineRuns merges sorted runs into a single new run in dir, removing them once it has.
+func combineRuns(dir string, runs []string) (string, error) {
+	file, err := os.CreateTemp(dir, spillPattern)
+	if err != nil {
+		return "", err
+	}
+
+	writer := bufio.NewWriter(file)
+	err = mergeRecords(runs, func(record spillRecord) { record.write(writer) })
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		file.Close()
+		return file.Name(), err
+	}
+	for _, run := range runs {
+		os.Remove(run)
+	}
+	return file.Name(), file.Close()
+}
+
+
+// mergeRuns merges sorted runs, returning the hashes shared by more than one file and how
+// many files had a hash of their own. While there are more runs than mergeFanIn, they are
+// combined into fewer, longer runs in dir first.
+func mergeRuns(runs []string, dir string) (CollisionTable, int, error) {
+	var combined []string
+	defer func() {
+		for _, run := range combined {
+			os.Remove(run)
+		}
+	}()
+
+	fanIn := mergeFanIn()
+	for len(runs) > fanIn {
+		var next []string
+		for start := 0; start < len(runs); start += fanIn {
+			end := start + fanIn
+			if end > len(runs) {
+				end = len(runs)
+			}
+			if end-start == 1 {
+				next = append(next, runs[start])
+				continue
+			}
+			run, err := combineRuns(dir, runs[start:end])
+			if run != "" {
+				combined = append(combined, run)
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			next = append(next, run)
+		}
+		runs = next
+	}
+
+	collisions := make(CollisionTable)
+	misses := 0
+	var hash string
+	var files []string
+	flush := func() {
+		if len(files) > 1 {
+			collisions[hash] = files
+		} else if len(files) == 1 {
+			misses++
+		}
+	}
+
+	err := mergeRecords(runs, func(record spillRecord) {
+		if record.hash != hash || files == nil {
+			flush()
+			hash, files = record.hash, nil
+		}
+		files = append(files, record.path)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	flush()
+
+	return collisions, misses, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+
+func TestSpillMergePasses(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// Room for only two runs at a time, so 500 runs take several passes to merge.
+	slots := openFileSlots
+	openFileSlots = make(chan struct{}, 3)
+	defer func() { openFileSlots = slots }()
+	if fanIn := mergeFanIn(); fanIn != 2 {
+		t.Fatalf("merging %d runs at a time, want 2", fanIn)
+	}
+
+	dir := t.TempDir()
+	replies := mixedReplies(1000)
+	collisions, err := spillAggregate(feed(replies), 2, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := aggregateHashes(feed(replies), nil)
+	for _, files := range want {
+		sort.Strings(files)
+	}
+	if !reflect.DeepEqual(collisions, want) {
+		t.Errorf("%d buckets, want %d", len(collisions), len(want))
+	}
+	if left, err := os.ReadDir(dir); err != nil || len(left) != 0 {
+		t.Errorf("%d runs left behind: %v", len(left), err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+// The consistency check of the heuristic modes.
+//
+// Hard links to the same file always have the same content, so a mode that compares files
+// properly can only ever put them in the same group. --quick, --ends-only, --trust-name-size
+// and --trust-mtime-size don't, so after they have grouped the files, every file in a group is
+// stat'd and any which are the same file as a file in another group are reported: the
+// heuristic disagreed with the filesystem, and the groups involved are noted as such.
+
+import (
+	"log"
+	"sort"
+)
+
+
+// splitLinkHashes are the groups holding a file which is also in another group.
+var splitLinkHashes = make(map[string]bool)
+
+
+// heuristicMode returns the option for the mode in use if it groups files without comparing
+// all of their content, or an empty string.
+func heuristicMode() string {
+	switch {
+	case *Quick:
+		return "--quick"
+	case *EndsOnly:
+		return "--ends-only"
+	case *TrustNameSize:
+		return "--trust-name-size"
+	case *TrustMtimeSize:
+		return "--trust-mtime-size"
+	}
+	return ""
+}
+
+
+// checkSplitLinks finds the files which are in one group but are hard links to a file in
+// another, logging each pair and marking their groups for annotate.
+func checkSplitLinks(collisions CollisionTable) {
+	type placement struct {
+		hash, file string
+	}
+	hashes := make([]string, 0, len(collisions))
+	for hash := range collisions {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	seen := make(map[[2]uint64]placement)
+	var pairs int
+	for _, hash := range hashes {
+		for _, file := range collisions[hash] {
+			info, err := fileSystem.Stat(file)
+			if err != nil {
+				continue
+			}
+			id, ok := fileID(info)
+			if !ok {
+				return
+			}
+			first, found := seen[id]
+			if !found {
+				seen[id] = placement{hash: hash, file: file}
+				continue
+			}
+			if first.hash != hash {
+				if pairs == 0 {
+					log.Print("Inconsistent: ", heuristicMode(), " put hard links to the same file in different groups:")
+				}
+				log.Printf("  %q and %q", first.file, file)
+				splitLinkHashes[first.hash], splitLinkHashes[hash] = true, true
+				pairs++
+			}
+		}
+	}
+	if pairs > 0 {
+		log.Print("Inconsistent: ", pairs, " hard links split across ", len(splitLinkHashes), " groups; without ", heuristicMode(), " they would be grouped by content")
+	}
+}
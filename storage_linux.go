@@ -0,0 +1,38 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+
+// isRotational reports whether the path is on spinning media, according to the rotational
+// flag of its block device in sysfs, and whether that could be found out at all.
+func isRotational(path string) (rotational, known bool) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return false, false
+	}
+	dev := uint64(stat.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+
+	// Partitions don't have a queue of their own, so fall back to the disk they are part of.
+	device, err := filepath.EvalSymlinks(filepath.Join("/sys/dev/block", strconv.FormatUint(major, 10)+":"+strconv.FormatUint(minor, 10)))
+	if err != nil {
+		return false, false
+	}
+	for _, dir := range []string{device, filepath.Dir(device)} {
+		if flag, err := os.ReadFile(filepath.Join(dir, "queue", "rotational")); err == nil {
+			return strings.TrimSpace(string(flag)) == "1", true
+		}
+	}
+	return false, false
+}
+
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+
+// isRotational can't tell what kind of storage a path is on here.
+func isRotational(path string) (rotational, known bool) {
+	return false, false
+}
@@ -0,0 +1,83 @@
+package main
+
+// Throttling of reads with --max-read-rate.
+//
+// A single token bucket is shared by all of the workers, so it is the total rate at which
+// findupe reads that is limited rather than the rate of each worker.
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+
+// throttleChunk is the most that a throttled read will read at once, which is also the size
+// of the bucket; it keeps one read from bursting far beyond the rate.
+const throttleChunk = 64 * 1024
+
+
+// rateLimiter is a token bucket of bytes.
+type rateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+
+// readLimiter is the limiter for --max-read-rate, or nil if reads aren't throttled.
+var readLimiter *rateLimiter
+
+
+// newRateLimiter creates a limiter which allows bytesPerSecond.
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	return &rateLimiter{rate: float64(bytesPerSecond), tokens: throttleChunk, last: time.Now()}
+}
+
+
+// take consumes n bytes worth of tokens, sleeping for however long it will take the bucket to
+// cover them if it has run dry.
+func (l *rateLimiter) take(n int) {
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > throttleChunk {
+		l.tokens = throttleChunk
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.lock.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / l.rate * float64(time.Second)))
+	}
+}
+
+
+// throttledReader is a reader whose reads are paced by a rateLimiter.
+type throttledReader struct {
+	reader  io.Reader
+	limiter *rateLimiter
+}
+
+
+// Read implements io.Reader.
+func (t throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.reader.Read(p)
+	t.limiter.take(n)
+	return n, err
+}
+
+
+// throttle wraps a reader with the --max-read-rate limit, if there is one.
+func throttle(reader io.Reader) io.Reader {
+	if readLimiter == nil {
+		return reader
+	}
+	return throttledReader{reader: reader, limiter: readLimiter}
+}
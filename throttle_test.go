@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+
+func TestMaxReadRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes a second")
+	}
+	const rate = 4 << 20
+	setFlag(t, "threads", "4")
+	readLimiter = newRateLimiter(rate)
+	defer func() { readLimiter = nil }()
+
+	// Four copies of a 1 MiB file between them take a second to read at 4 MiB/s, whichever
+	// workers read them.
+	dir := t.TempDir()
+	content := string(bytes.Repeat([]byte("throttled "), 1<<20/10))
+	writeFiles(t, dir, map[string]string{"a": content, "b": content, "c": content, "d": content})
+
+	start := time.Now()
+	checkGroups(t, dir, scan(t, dir), "a b c d")
+	elapsed := time.Since(start)
+
+	want := time.Duration(float64(4*len(content)) / rate * float64(time.Second))
+	if elapsed < want*8/10 || elapsed > want*3/2 {
+		t.Errorf("reading %d bytes took %s, want about %s", 4*len(content), elapsed, want)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+// The json-tree format, which nests the duplicates in the directory hierarchy they came from,
+// rooting each tree at the root it was found under.
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+
+// TreeNode is a directory, or a duplicate file, in the json-tree output.
+type TreeNode struct {
+	Name string `json:"name"`
+	// Group is the hash of the group a file belongs to; directories have none.
+	Group string `json:"group,omitempty"`
+	// GroupID is the --group-ids id of that group.
+	GroupID string `json:"group_id,omitempty"`
+	// Kept is set for the file of its group which would be kept.
+	Kept bool `json:"kept,omitempty"`
+	// WastedBytes is what removing the file would reclaim (nothing for the kept file), or, for
+	// a directory, the total of everything beneath it.
+	WastedBytes int64       `json:"wasted_bytes"`
+	Children    []*TreeNode `json:"children,omitempty"`
+
+	children map[string]*TreeNode
+}
+
+
+// child returns the named child of a node, creating it if necessary.
+func (n *TreeNode) child(name string) *TreeNode {
+	if n.children == nil {
+		n.children = make(map[string]*TreeNode)
+	}
+	node, exists := n.children[name]
+	if !exists {
+		node = &TreeNode{Name: name}
+		n.children[name] = node
+	}
+	return node
+}
+
+
+// addGroup places each file of a group in the tree under its directories.
+func (n *TreeNode) addGroup(group Group) {
+	for i, file := range group.Files {
+		node := n
+		if len(roots) > 1 {
+			node = node.child(rootOf(file))
+		}
+		for _, part := range strings.Split(relativePath(file), "/") {
+			node = node.child(part)
+		}
+		node.Group, node.GroupID = group.Hash, group.ID
+		node.Kept = i == 0
+		if !node.Kept {
+			node.WastedBytes = group.Size
+		}
+	}
+}
+
+
+// finish fills in the Children lists, in name order, and the directory totals.
+func (n *TreeNode) finish() int64 {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		node := n.children[name]
+		n.WastedBytes += node.finish()
+		n.Children = append(n.Children, node)
+	}
+
+	return n.WastedBytes
+}
+
+
+// writeTree encodes the finished tree to stdout.
+func writeTree(root *TreeNode) {
+	root.finish()
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(root); err != nil {
+		log.Printf("error writing tree: %s", err.Error())
+	}
+}
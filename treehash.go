@@ -0,0 +1,68 @@
+package main
+
+// The fingerprint of the whole tree, for --tree-hash.
+//
+// The hash of every file is collected on its way to the aggregation, and once everything has
+// been hashed the sorted list of them, each with the file's path relative to its root, is
+// hashed in turn. Two trees holding the same files under the same names have the same
+// fingerprint wherever they are mounted; with --tree-hash-content-only the names are left out,
+// so that only what the trees hold has to match, however it is laid out.
+//
+// As with --dirs, only files that were hashed count, so files skipped for being too small,
+// excluded or special don't stop two trees matching.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sort"
+)
+
+
+// treeEntries are the files seen by the tap, as path and hash (or just hash) lines; only the
+// tap updates them.
+var treeEntries []string
+
+
+// treeTap records the hash of every reply passing through it, forwarding them on to the
+// returned channel.
+func treeTap(replies <-chan *FileHash) <-chan *FileHash {
+	tapped := make(chan *FileHash, cap(replies))
+
+	go func() {
+		defer close(tapped)
+		for reply := range replies {
+			entry := reply.Hash
+			if !*TreeHashContentOnly {
+				entry = relativePath(reply.Pathname) + "\x00" + entry
+			}
+			treeEntries = append(treeEntries, entry)
+			tapped <- reply
+		}
+	}()
+
+	return tapped
+}
+
+
+// treeHash is the hash of the sorted entries, so that it doesn't depend on the order the
+// files were walked or hashed in.
+func treeHash() string {
+	sort.Strings(treeEntries)
+	hasher := sha256.New()
+	for _, entry := range treeEntries {
+		hasher.Write([]byte(entry))
+		hasher.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+
+// logTreeHash logs the fingerprint of the tree, and what went into it.
+func logTreeHash() {
+	what := "paths and contents"
+	if *TreeHashContentOnly {
+		what = "contents"
+	}
+	log.Printf("Tree hash: %s (the %s of %d files)", treeHash(), what, len(treeEntries))
+}
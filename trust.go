@@ -0,0 +1,26 @@
+package main
+
+// The --trust-name-size and --trust-mtime-size modes, which bucket files on their base name or
+// modification time and their size without reading them at all. They are the fastest modes
+// there are, and the least reliable: files with the same name and size are often different
+// (logs, configs, anything edited in place), and while files sharing a size and a timestamp to
+// the nanosecond are usually copies made with their times preserved, nothing says they must
+// be, so nothing either finds should be acted on without --verify.
+
+import (
+	"fmt"
+)
+
+
+// nameSizeRequest keys a request on its base name and size.
+func nameSizeRequest(request *FileHash) *FileHash {
+	request.Hash = fmt.Sprintf("%016d.name.%s", request.Size, baseName(request.Pathname)) + metadataKey(request)
+	return request
+}
+
+
+// mtimeSizeRequest keys a request on its modification time and size.
+func mtimeSizeRequest(request *FileHash) *FileHash {
+	request.Hash = fmt.Sprintf("%016d.mtime.%d", request.Size, request.ModTime.UnixNano()) + metadataKey(request)
+	return request
+}
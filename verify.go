@@ -0,0 +1,196 @@
+package main
+
+// The --verify pass, which compares the files of each group byte by byte before they are
+// reported, so that nothing rests on the hash (or, with --quick or --trust-name-size, the
+// heuristic) alone. Files are compared as they were hashed, so --offset, --length and
+// --text-normalize apply.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+)
+
+
+// verifyChunk is how much of each file is compared at a time.
+const verifyChunk = 64 * 1024
+
+
+// sameContent reports whether two files have the same content.
+func sameContent(first, second string) (bool, error) {
+	file1, reader1, err := openContent(first)
+	if err != nil {
+		return false, err
+	}
+	defer file1.Close()
+
+	file2, reader2, err := openContent(second)
+	if err != nil {
+		return false, err
+	}
+	defer file2.Close()
+
+	buffer1, buffer2 := make([]byte, verifyChunk), make([]byte, verifyChunk)
+	for {
+		n1, err1 := io.ReadFull(reader1, buffer1)
+		n2, err2 := io.ReadFull(reader2, buffer2)
+		if n1 != n2 || !bytes.Equal(buffer1[:n1], buffer2[:n2]) {
+			return false, nil
+		}
+
+		end1 := err1 == io.EOF || err1 == io.ErrUnexpectedEOF
+		end2 := err2 == io.EOF || err2 == io.ErrUnexpectedEOF
+		switch {
+		case err1 != nil && !end1:
+			return false, err1
+		case err2 != nil && !end2:
+			return false, err2
+		case end1 || end2:
+			return end1 == end2, nil
+		}
+	}
+}
+
+
+// contentClasses divides files into sets with the same content, dropping any that can't be
+// read.
+func contentClasses(files []string) [][]string {
+	var classes [][]string
+	for _, file := range files {
+		matched := false
+		for i, class := range classes {
+			same, err := sameContent(class[0], file)
+			if err != nil {
+				fileError("verifying", file, err)
+				matched = true
+				break
+			}
+			if same {
+				classes[i], matched = append(class, file), true
+				break
+			}
+		}
+		if !matched {
+			classes = append(classes, []string{file})
+		}
+	}
+	return classes
+}
+
+
+// verifiedBucket is the outcome of comparing the files of one bucket.
+type verifiedBucket struct {
+	hash    string
+	files   int
+	classes [][]string
+}
+
+
+// verifyWorkers is how many buckets to compare at once: --threads, but no more than can have
+// both of their files open within --max-open-files, so that no worker is left holding one file
+// while it waits for a slot for the other.
+func verifyWorkers() int {
+	workers := *Threads
+	if openFileSlots != nil && cap(openFileSlots)/2 < workers {
+		workers = cap(openFileSlots) / 2
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+
+// verifyBuckets compares the files of each of the buckets on a pool of workers, sending each
+// outcome back to the returned channel.
+func verifyBuckets(collisions CollisionTable, hashes []string) <-chan verifiedBucket {
+	pending := make(chan string, len(hashes))
+	for _, hash := range hashes {
+		pending <- hash
+	}
+	close(pending)
+
+	verified := make(chan verifiedBucket, len(hashes))
+	var workerGroup sync.WaitGroup
+	workers := verifyWorkers()
+	workerGroup.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer workerGroup.Done()
+			for hash := range pending {
+				files := collisions[hash]
+				verified <- verifiedBucket{hash: hash, files: len(files), classes: contentClasses(files)}
+			}
+		}()
+	}
+	go func() {
+		workerGroup.Wait()
+		close(verified)
+	}()
+
+	return verified
+}
+
+
+// splitFrom maps the key of each group split off another by --verify to the hash its files
+// share with that group.
+var splitFrom = make(map[string]string)
+
+
+// unsplitHash returns the hash the files of a group have, which for a group split off by
+// --verify isn't its key.
+func unsplitHash(key string) string {
+	if hash, ok := splitFrom[key]; ok {
+		return hash
+	}
+	return key
+}
+
+
+// verifyCollisions compares the files of every bucket, splitting buckets whose files turn out
+// to differ and dropping any files that are left without a match.
+func verifyCollisions(collisions CollisionTable) {
+	hashes := make([]string, 0, len(collisions))
+	for hash := range collisions {
+		// Archives matched on their members are meant to differ in their bytes.
+		if !isArchiveHash(hash) {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+
+	// The workers only read the table, so it isn't changed until they have all finished.
+	outcomes := make([]verifiedBucket, 0, len(hashes))
+	for outcome := range verifyBuckets(collisions, hashes) {
+		outcomes = append(outcomes, outcome)
+	}
+
+	var split, unmatched int
+	for _, outcome := range outcomes {
+		delete(collisions, outcome.hash)
+		if len(outcome.classes) > 1 {
+			split++
+		}
+
+		kept, matched := 0, 0
+		for _, class := range outcome.classes {
+			if len(class) < 2 {
+				continue
+			}
+			key := outcome.hash
+			if kept > 0 {
+				key = fmt.Sprintf("%s.%d", outcome.hash, kept)
+				splitFrom[key] = outcome.hash
+			}
+			collisions[key] = class
+			kept++
+			matched += len(class)
+		}
+		unmatched += outcome.files - matched
+	}
+
+	log.Print("Verified: ", len(collisions), " groups, ", split, " split because their files differed, ", unmatched, " files without a match.")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+
+func BenchmarkVerifyBuckets(b *testing.B) {
+	// Many medium buckets: 64 of four 256KiB copies each.
+	dir := b.TempDir()
+	collisions := make(CollisionTable)
+	for i := 0; i < 64; i++ {
+		content := bytes.Repeat([]byte(fmt.Sprintf("bucket %d ", i)), 256<<10/10)
+		hash := fmt.Sprintf("%016d.%d", len(content), i)
+		for c := 0; c < 4; c++ {
+			path := filepath.Join(dir, fmt.Sprintf("%d-%d", i, c))
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				b.Fatal(err)
+			}
+			collisions[hash] = append(collisions[hash], path)
+		}
+	}
+	hashes := make([]string, 0, len(collisions))
+	for hash := range collisions {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	threads := *Threads
+	defer func() { *Threads = threads }()
+	for _, workers := range []int{1, verifyWorkers()} {
+		*Threads = workers
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for outcome := range verifyBuckets(collisions, hashes) {
+					if len(outcome.classes) != 1 {
+						b.Fatalf("%s was split", outcome.hash)
+					}
+				}
+			}
+		})
+	}
+}